@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+const (
+	// maxSubscriptions is the number of channels a client may subscribe to.
+	maxSubscriptions = 16
+	// maxMessages is the number of messages kept for a client, until it
+	// reads them. Further messages are dropped.
+	maxMessages = 64
+)
+
+// channelName matches valid channel names.
+var channelName = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+var (
+	errBadChannel      = errors.New("invalid channel name")
+	errSubscribed      = errors.New("already subscribed to this channel")
+	errTooManyChannels = errors.New("subscribed to too many channels")
+	errNotSubscribed   = errors.New("not subscribed to this channel")
+	errNoSubscribers   = errors.New("nobody is subscribed to this channel")
+)
+
+// message is a message sent to a channel.
+type message struct {
+	channel string
+	text    string
+}
+
+// subscribe subscribes s to channel.
+func (s *session) subscribe(channel string) error {
+	if !channelName.MatchString(channel) {
+		return errBadChannel
+	}
+	if s.channels[channel] {
+		return errSubscribed
+	}
+	if len(s.channels) >= maxSubscriptions {
+		return errTooManyChannels
+	}
+	s.channels[channel] = true
+
+	return nil
+}
+
+// unsubscribe unsubscribes s from channel.
+func (s *session) unsubscribe(channel string) error {
+	if !s.channels[channel] {
+		return errNotSubscribed
+	}
+	delete(s.channels, channel)
+
+	return nil
+}
+
+// deliver queues m for s, unless its queue is full, and wakes it up.
+func (s *session) deliver(m message) {
+	if len(s.messages) >= maxMessages {
+		return
+	}
+	s.messages = append(s.messages, m)
+	s.changed(IdleMessage)
+}
+
+// readMessages writes MPD-response-formatted messages queued for s, and
+// empties its queue.
+func (s *session) readMessages(w io.Writer) {
+	for _, m := range s.messages {
+		fmt.Fprintf(w, "channel: %s\nmessage: %s\n", m.channel, m.text)
+	}
+	s.messages = nil
+}
+
+// channels returns the channels sessions are subscribed to.
+func (ss *sessions) channels() []string {
+	ss.Lock()
+	defer ss.Unlock()
+	seen := make(map[string]bool)
+	var channels []string
+	for s := range ss.sessions {
+		for channel := range s.channels {
+			if !seen[channel] {
+				seen[channel] = true
+				channels = append(channels, channel)
+			}
+		}
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// send sends text to the sessions subscribed to channel.
+func (ss *sessions) send(channel, text string) error {
+	if !channelName.MatchString(channel) {
+		return errBadChannel
+	}
+
+	ss.Lock()
+	defer ss.Unlock()
+	sent := false
+	for s := range ss.sessions {
+		if s.channels[channel] {
+			s.deliver(message{channel, text})
+			sent = true
+		}
+	}
+	if !sent {
+		return errNoSubscribers
+	}
+
+	return nil
+}
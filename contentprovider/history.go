@@ -0,0 +1,139 @@
+package contentprovider
+
+import (
+	"database/sql"
+	"time"
+)
+
+// sqlCreateHistory stores the tracks played, and whether their plays were
+// reported to Google Play Music.
+var sqlCreateHistory []string = []string{
+	`CREATE TABLE history (
+    id INTEGER PRIMARY KEY,
+    trackId VARCHAR(255) NOT NULL,
+    playedAt INTEGER NOT NULL,
+    listened INTEGER NOT NULL,
+    reported INTEGER NOT NULL DEFAULT 0)`,
+	`CREATE INDEX history_trackId ON history(trackId)`,
+}
+
+// sqlCountReportAttempts counts the failed attempts to report each play.
+var sqlCountReportAttempts []string = []string{
+	`ALTER TABLE history ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+}
+
+// maxReportAttempts is the number of times reporting a play may fail
+// before it's given up on, as Google Play Music rejects some plays for
+// good.
+const maxReportAttempts = 5
+
+// PlayStats describes how often, and how long, tracks were played.
+type PlayStats struct {
+	Plays      int           // number of plays
+	Tracks     int           // number of distinct tracks played
+	Listened   time.Duration // total time listened
+	LastPlayed time.Time     // when the last play started, zero if never
+}
+
+// RecordPlay adds a play of a track to the history, and reports it, and any
+// play not reported yet, to Google Play Music's play counts. The play is
+// recorded even if reporting fails, to be reported with the next one.
+func (cp *ContentProvider) RecordPlay(trackID string, playedAt time.Time, listened time.Duration) error {
+	_, err := cp.db.Exec(`INSERT INTO history(trackId, playedAt, listened)
+	  VALUES (?, ?, ?)`, trackID, playedAt.Unix(), int64(listened/time.Millisecond))
+	if err != nil {
+		return err
+	}
+
+	return cp.reportPlays()
+}
+
+// reportPlays reports plays not reported yet to Google Play Music. A play
+// failing to be reported doesn't hold back the others. Its failure counts
+// as an attempt only if another play got through, so that no play is
+// given up on while Google Play Music can't be reached. The first error
+// is returned.
+func (cp *ContentProvider) reportPlays() error {
+	cp.reporting.Lock()
+	defer cp.reporting.Unlock()
+
+	rows, err := cp.db.Query(`SELECT id, trackId, playedAt FROM history
+	  WHERE reported = 0 AND attempts < ? ORDER BY id`, maxReportAttempts)
+	if err != nil {
+		return err
+	}
+	type play struct {
+		id       int64
+		trackID  string
+		playedAt int64
+	}
+	var plays []play
+	for rows.Next() {
+		var p play
+		err = rows.Scan(&p.id, &p.trackID, &p.playedAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		plays = append(plays, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	var failed []int64
+	reported := false
+	for _, p := range plays {
+		err = cp.gpm.IncrementPlayCount(p.trackID, 1, time.Unix(p.playedAt, 0))
+		if err == nil {
+			_, err = cp.db.Exec(`UPDATE history SET reported = 1 WHERE id = ?`, p.id)
+			reported = reported || err == nil
+		} else {
+			failed = append(failed, p.id)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if !reported {
+		return firstErr
+	}
+	for _, id := range failed {
+		_, err = cp.db.Exec(`UPDATE history SET attempts = attempts + 1 WHERE id = ?`, id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// TrackPlayStats returns the play statistics of a track.
+func (cp *ContentProvider) TrackPlayStats(trackID string) (PlayStats, error) {
+	return cp.playStats(`WHERE trackId = ?`, trackID)
+}
+
+// HistoryPlayStats returns the play statistics of the whole history.
+func (cp *ContentProvider) HistoryPlayStats() (PlayStats, error) {
+	return cp.playStats("")
+}
+
+// playStats returns the play statistics of the plays where selects.
+func (cp *ContentProvider) playStats(where string, args ...interface{}) (PlayStats, error) {
+	var stats PlayStats
+	var listened, lastPlayed sql.NullInt64
+	err := cp.db.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT trackId),
+	  SUM(listened), MAX(playedAt) FROM history `+where, args...).Scan(
+		&stats.Plays, &stats.Tracks, &listened, &lastPlayed)
+	if err != nil {
+		return stats, err
+	}
+	stats.Listened = time.Duration(listened.Int64) * time.Millisecond
+	if lastPlayed.Valid {
+		stats.LastPlayed = time.Unix(lastPlayed.Int64, 0)
+	}
+
+	return stats, nil
+}
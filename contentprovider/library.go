@@ -0,0 +1,265 @@
+package contentprovider
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// sqlCreateLibrary tracks which cached tracks belong to the user's library,
+// and when the library was last synchronised.
+var sqlCreateLibrary []string = []string{
+	`ALTER TABLE tracks ADD COLUMN inLibrary INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE sync_state (
+    key VARCHAR(255) NOT NULL PRIMARY KEY,
+    value VARCHAR(255) NOT NULL)`,
+}
+
+// inLibrary values.
+const (
+	notInLibrary     = 0
+	inLibrary        = 1
+	inLibraryPending = 2 // not seen yet during a full synchronisation
+)
+
+// lastSyncKey is the sync_state key of the last modification time of the
+// library's tracks, as of the last synchronisation, in microseconds since
+// the epoch as used by the service.
+const lastSyncKey = "library.lastSync"
+
+// SyncResult describes a library synchronisation.
+type SyncResult struct {
+	Updated int // tracks added or updated
+	Removed int // tracks removed from the library
+}
+
+// Changed reports whether the synchronisation changed the library.
+func (r SyncResult) Changed() bool {
+	return r.Updated > 0 || r.Removed > 0
+}
+
+// LibraryStats describes the user's library.
+type LibraryStats struct {
+	Artists  int
+	Albums   int
+	Songs    int
+	Playtime time.Duration // total duration of the songs
+}
+
+// LibraryStats returns statistics of the user's library.
+func (cp *ContentProvider) LibraryStats() (LibraryStats, error) {
+	var stats LibraryStats
+	var millis sql.NullInt64
+	err := cp.db.QueryRow(`SELECT COUNT(DISTINCT artist),
+	  COUNT(DISTINCT artist || char(0) || album), COUNT(*), SUM(duration)
+	  FROM tracks WHERE inLibrary = ?`, inLibrary).Scan(&stats.Artists,
+		&stats.Albums, &stats.Songs, &millis)
+	stats.Playtime = time.Duration(millis.Int64) * time.Millisecond
+
+	return stats, err
+}
+
+// LastSync returns when the library last changed, as of its last
+// synchronisation, or the zero time if it never was synchronised.
+func (cp *ContentProvider) LastSync() time.Time {
+	var value string
+	err := cp.db.QueryRow("SELECT value FROM sync_state WHERE key = ?",
+		lastSyncKey).Scan(&value)
+	if err != nil {
+		return time.Time{}
+	}
+	micros, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(0, micros*int64(time.Microsecond))
+}
+
+// SyncLibrary pulls the user's library into the cache. An incremental
+// synchronisation only asks for tracks changed since the last one, a full
+// one downloads the whole library and drops tracks that are gone from it.
+// Synchronisations run one at a time.
+func (cp *ContentProvider) SyncLibrary(full bool) (SyncResult, error) {
+	cp.syncing.Lock()
+	defer cp.syncing.Unlock()
+
+	return cp.syncLibrary(full)
+}
+
+// syncLibrary synchronises the library, with cp.syncing held.
+func (cp *ContentProvider) syncLibrary(full bool) (SyncResult, error) {
+	var result SyncResult
+	var since int64
+	if last := cp.LastSync(); !full && !last.IsZero() {
+		since = last.UnixNano() / int64(time.Microsecond)
+	}
+	trackList, err := cp.gpm.TrackListUpdatedSince(since)
+	if err != nil {
+		return result, err
+	}
+	// The next synchronisation asks for changes since the last one seen,
+	// by the service's clock rather than ours, which may be off.
+	newest := since
+	for _, item := range trackList.Data.Items {
+		modified, err := strconv.ParseInt(item.LastModifiedTimestamp, 10, 64)
+		if err == nil && modified > newest {
+			newest = modified
+		}
+	}
+
+	err = cp.store.inTx(func(tx *sql.Tx) error {
+		if full {
+			_, err := tx.Exec("UPDATE tracks SET inLibrary = ? WHERE inLibrary = ?",
+				inLibraryPending, inLibrary)
+			if err != nil {
+				return err
+			}
+		}
+		var tracks []Track
+		for _, item := range trackList.Data.Items {
+			track := Track(item)
+			if !track.Deleted {
+				tracks = append(tracks, track)
+				continue
+			}
+			err := cp.store.deleteTrackTx(tx, track.ID)
+			if err != nil {
+				return err
+			}
+			result.Removed++
+		}
+		err := cp.store.persistTracksTx(tx, tracks, inLibrary)
+		if err != nil {
+			return err
+		}
+		result.Updated = len(tracks)
+		if full {
+			removed, err := deletePendingTracks(tx)
+			if err != nil {
+				return err
+			}
+			result.Removed += removed
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO sync_state(key, value) VALUES (?, ?)",
+			lastSyncKey, strconv.FormatInt(newest, 10))
+
+		return err
+	})
+
+	return result, err
+}
+
+// deletePendingTracks removes library tracks a full synchronisation did not
+// see, and returns how many there were.
+func deletePendingTracks(tx *sql.Tx) (int, error) {
+	_, err := tx.Exec(`DELETE FROM tracks_fts WHERE id IN
+	  (SELECT id FROM tracks WHERE inLibrary = ?)`, inLibraryPending)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM tracks WHERE inLibrary = ?", inLibraryPending)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+
+	return int(removed), err
+}
+
+// firstSync synchronises the library in full, unless another
+// synchronisation did while waiting for it.
+func (cp *ContentProvider) firstSync() error {
+	cp.syncing.Lock()
+	defer cp.syncing.Unlock()
+	if !cp.LastSync().IsZero() {
+		return nil
+	}
+	_, err := cp.syncLibrary(true)
+
+	return err
+}
+
+// UserTracks returns the tracks in the user's library, synchronising it
+// first if it never was.
+func (cp *ContentProvider) UserTracks() ([]Track, error) {
+	if cp.LastSync().IsZero() {
+		err := cp.firstSync()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := cp.db.Query(`SELECT `+trackColumns+`
+      FROM tracks WHERE inLibrary = ? ORDER BY artist, album, title`,
+		inLibrary)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tracks []Track
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// LibraryArtists returns the names of artists in the user's library.
+func (cp *ContentProvider) LibraryArtists() ([]string, error) {
+	return cp.libraryStrings(`SELECT DISTINCT(artist) FROM tracks
+	  WHERE inLibrary = ? AND artist <> "" ORDER BY artist`, inLibrary)
+}
+
+// LibraryAlbums returns the names of albums by artist in the user's
+// library.
+func (cp *ContentProvider) LibraryAlbums(artist string) ([]string, error) {
+	return cp.libraryStrings(`SELECT DISTINCT(album) FROM tracks
+	  WHERE inLibrary = ? AND artist = ? ORDER BY album`, inLibrary, artist)
+}
+
+// LibraryTracks returns the tracks of an album by artist in the user's
+// library.
+func (cp *ContentProvider) LibraryTracks(artist, album string) ([]Track, error) {
+	rows, err := cp.db.Query(`SELECT `+trackColumns+`
+      FROM tracks WHERE inLibrary = ? AND artist = ? AND album = ?
+      ORDER BY discNumber, trackNumber, title`, inLibrary, artist, album)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tracks []Track
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// libraryStrings runs a query selecting a single string column.
+func (cp *ContentProvider) libraryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := cp.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
@@ -8,15 +8,20 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 
 	"github.com/amir/gpm"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type ContentProvider struct {
-	gpm      *gpm.Client
-	db       *sql.DB
-	deviceID string
+	gpm        *gpm.Client
+	db         *sql.DB
+	store      *store // prepared cache queries
+	deviceID   string
+	streamURLs *streamURLCache // resolved stream URLs, until they expire
+	reporting  sync.Mutex      // serialises reporting plays
+	syncing    sync.Mutex      // serialises library synchronisations
 }
 
 // Track is a gpm.Track type alias.
@@ -28,6 +33,16 @@ type Album gpm.Album
 // Artist is a gpm.Artist type alias.
 type Artist gpm.Artist
 
+// key returns the ID a track is cached and listed under: its library ID,
+// or its store ID for tracks outside the library, which have none.
+func (t Track) key() string {
+	if t.ID == "" {
+		return t.Nid
+	}
+
+	return t.ID
+}
+
 // String returns MPD-response-formatted representation of a track.
 func (t Track) String() string {
 	var buffer bytes.Buffer
@@ -36,20 +51,31 @@ func (t Track) String() string {
 	if err != nil {
 		duration = 0
 	}
-	if t.ID == "" {
-		buffer.WriteString("file: " + t.Nid + "\n")
-	} else {
-		buffer.WriteString("file: " + t.ID + "\n")
-	}
+	buffer.WriteString("file: " + t.key() + "\n")
 	buffer.WriteString("Time: " + strconv.Itoa(duration/1000) + "\n")
 	buffer.WriteString("Artist: " + t.Artist + "\n")
 	buffer.WriteString("Title: " + t.Title + "\n")
 	buffer.WriteString("Album: " + t.Album + "\n")
+	if t.AlbumArtist != "" {
+		buffer.WriteString("AlbumArtist: " + t.AlbumArtist + "\n")
+	}
+	if t.Genre != "" {
+		buffer.WriteString("Genre: " + t.Genre + "\n")
+	}
+	if t.Year > 0 {
+		buffer.WriteString("Date: " + strconv.Itoa(t.Year) + "\n")
+	}
+	if t.TrackNumber > 0 {
+		buffer.WriteString("Track: " + strconv.Itoa(t.TrackNumber) + "\n")
+	}
+	if t.DiscNumber > 0 {
+		buffer.WriteString("Disc: " + strconv.Itoa(t.DiscNumber) + "\n")
+	}
 
 	return buffer.String()
 }
 
-var schemaVersion = 1
+var schemaVersion = len(sqlMigrations)
 
 var sqlCreateTables []string = []string{
 	`CREATE TABLE tracks (
@@ -67,6 +93,44 @@ var sqlCreateTables []string = []string{
     year CHAR(4))`,
 }
 
+// sqlCreateSearchIndex creates full-text indexes over tracks and albums,
+// folding case and diacritics.
+var sqlCreateSearchIndex []string = []string{
+	`CREATE VIRTUAL TABLE tracks_fts USING fts5(
+    title, album, artist, id UNINDEXED,
+    tokenize = 'unicode61 remove_diacritics 2')`,
+	`CREATE VIRTUAL TABLE albums_fts USING fts5(
+    name, artist, id UNINDEXED,
+    tokenize = 'unicode61 remove_diacritics 2')`,
+	`INSERT INTO tracks_fts(title, album, artist, id)
+    SELECT title, album, artist, id FROM tracks`,
+	`INSERT INTO albums_fts(name, artist, id)
+    SELECT name, artist, id FROM albums`,
+}
+
+// sqlAddTrackMetadata stores the rest of tracks' tags.
+var sqlAddTrackMetadata []string = []string{
+	`ALTER TABLE tracks ADD COLUMN albumArtist VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN genre VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN trackNumber INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN discNumber INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN year INTEGER NOT NULL DEFAULT 0`,
+}
+
+// sqlMigrations holds the statements bringing the schema from version i to
+// version i+1.
+var sqlMigrations [][]string = [][]string{
+	sqlCreateTables,
+	sqlCreateSearchIndex,
+	sqlCreateLibrary,
+	sqlAddReplayGain,
+	sqlAddTrackMetadata,
+	sqlCreateStickers,
+	sqlCreateHistory,
+	sqlDropUnkeyedTracks,
+	sqlCountReportAttempts,
+}
+
 func New(email, password, cacheDir string) (*ContentProvider, error) {
 	gpmClient, deviceID, err := newGPMClient(email, password)
 	if err != nil {
@@ -77,11 +141,18 @@ func New(email, password, cacheDir string) (*ContentProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	store, err := newStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return &ContentProvider{
-		gpm:      gpmClient,
-		db:       db,
-		deviceID: deviceID,
+		gpm:        gpmClient,
+		db:         db,
+		store:      store,
+		deviceID:   deviceID,
+		streamURLs: newStreamURLCache(),
 	}, nil
 }
 
@@ -119,33 +190,45 @@ func newDb(cacheDir string) (*sql.DB, error) {
 		}
 	}
 	path := filepath.Join(cacheDir, "content-provider.db")
-	if fi, err := os.Stat(path); os.IsNotExist(err) || (fi != nil && fi.Size() == 0) {
-		err = initSqliteDatabase(path)
-		if err != nil {
-			return nil, err
-		}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	err = migrateSqliteDatabase(db)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", path)
-	return db, err
+	return db, nil
 }
 
-func initSqliteDatabase(path string) error {
-	_, err := os.Create(path)
+// migrateSqliteDatabase brings db's schema up to schemaVersion, running
+// each pending migration in its own transaction.
+func migrateSqliteDatabase(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
 	if err != nil {
 		return err
 	}
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	for _, createTableSql := range sqlCreateTables {
-		_, err = db.Exec(createTableSql)
+	for ; version < schemaVersion; version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, migrationSql := range sqlMigrations[version] {
+			_, err = tx.Exec(migrationSql)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("schema version %d: %v", version+1, err)
+			}
+		}
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
 		if err != nil {
+			tx.Rollback()
 			return err
 		}
-		_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion))
+		err = tx.Commit()
 		if err != nil {
 			return err
 		}
@@ -154,57 +237,49 @@ func initSqliteDatabase(path string) error {
 	return nil
 }
 
-func (cp *ContentProvider) persistTrack(track Track) {
-	stmt, _ := cp.db.Prepare(`
-	  INSERT INTO tracks(id, nid, title, album, artist, albumId, duration)
-	  VALUES (?, ?, ?, ?, ?, ?, ?)`)
-
-	stmt.Exec(track.ID, track.Nid, track.Title, track.Album, track.Artist,
-		track.AlbumID, track.DurationMillis)
-}
-
-func (cp *ContentProvider) retrieveTrack(trackID string) Track {
-	var track Track
-	cp.db.QueryRow(`SELECT
-      id, nid, title, album, albumId, artist, duration
-      FROM tracks WHERE id = ?`,
-		trackID).Scan(&track.ID, &track.Nid, &track.Title, &track.Album,
-		&track.AlbumID, &track.Artist, &track.DurationMillis)
-
-	return track
-}
-
-func (cp *ContentProvider) persistAlbum(album Album) {
-	stmt, _ := cp.db.Prepare("INSERT INTO albums(id, name, artist, year) VALUES (?, ?, ?, ?)")
-
-	stmt.Exec(album.ID, album.Name, album.Artist, album.Year)
-}
-
+// TrackStreamURL returns a stream URL for track, reusing a previously
+// resolved URL until it expires.
 func (cp *ContentProvider) TrackStreamURL(track string) (string, error) {
+	if url, ok := cp.streamURLs.get(track); ok {
+		return url, nil
+	}
+
 	url, err := cp.gpm.MP3StreamURL(track, cp.deviceID)
 	if err != nil {
 		return "", err
 	}
+	cp.streamURLs.put(track, url)
 
 	return url, nil
 }
 
+// InvalidateStreamURL drops a cached stream URL for track, e.g. when the
+// service refused it.
+func (cp *ContentProvider) InvalidateStreamURL(track string) {
+	cp.streamURLs.invalidate(track)
+}
+
+// StreamURLCacheStats returns stream URL cache metrics.
+func (cp *ContentProvider) StreamURLCacheStats() StreamURLCacheStats {
+	return cp.streamURLs.snapshot()
+}
+
 func (cp *ContentProvider) Playlists() ([]gpm.Playlist, error) {
-	return cp.Playlists()
+	return cp.gpm.Playlists()
 }
 
 func (cp *ContentProvider) FindTrack(trackID string) (Track, error) {
-	track := cp.retrieveTrack(trackID)
-	if track.ID == "" {
+	track, err := cp.store.retrieveTrack(trackID)
+	if err == sql.ErrNoRows {
 		gpmTrack, err := cp.gpm.TrackInfo(trackID)
 		if err != nil {
 			return track, err
 		}
 		track = Track(gpmTrack)
-		cp.persistTrack(track)
+		return track, cp.store.persistTracks([]Track{track})
 	}
 
-	return track, nil
+	return track, err
 }
 
 func (cp *ContentProvider) FindTracks(query string) ([]Track, error) {
@@ -214,11 +289,14 @@ func (cp *ContentProvider) FindTracks(query string) ([]Track, error) {
 	}
 	var tracks = make([]Track, len(gpmTracks))
 	for i, track := range gpmTracks {
-		t := Track(track)
-		cp.persistTrack(t)
-		tracks[i] = t
+		tracks[i] = Track(track)
+	}
+	err = cp.store.persistTracks(tracks)
+	if err != nil {
+		return nil, err
 	}
-	return tracks, err
+
+	return tracks, nil
 }
 
 func (cp *ContentProvider) FindAlbum(albumID string, includeTracks bool) (Album, error) {
@@ -228,9 +306,19 @@ func (cp *ContentProvider) FindAlbum(albumID string, includeTracks bool) (Album,
 		return album, err
 	}
 	album = Album(gpmAlbum)
-	cp.persistAlbum(album)
+	err = cp.store.persistAlbums([]Album{album})
+	if err != nil {
+		return album, err
+	}
+	if includeTracks {
+		tracks := make([]Track, len(album.Tracks))
+		for i, track := range album.Tracks {
+			tracks[i] = Track(track)
+		}
+		err = cp.store.persistTracks(tracks)
+	}
 
-	return album, nil
+	return album, err
 }
 
 func (cp *ContentProvider) FindAlbums(query string) ([]Album, error) {
@@ -240,38 +328,28 @@ func (cp *ContentProvider) FindAlbums(query string) ([]Album, error) {
 	}
 	var albums = make([]Album, len(gpmAlbums))
 	for i, album := range gpmAlbums {
-		a := Album(album)
-		cp.persistAlbum(a)
-		albums[i] = a
+		albums[i] = Album(album)
 	}
-
-	return albums, err
-}
-
-func (cp *ContentProvider) UserTracks() ([]Track, error) {
-	gpmTrackList, err := cp.gpm.TrackList()
+	err = cp.store.persistAlbums(albums)
 	if err != nil {
 		return nil, err
 	}
-	var tracks = make([]Track, len(gpmTrackList.Data.Items))
-	for i, track := range gpmTrackList.Data.Items {
-		t := Track(track)
-		cp.persistTrack(t)
-		tracks[i] = t
-	}
 
-	return tracks, nil
+	return albums, nil
 }
 
 func (cp *ContentProvider) ListArtists(query string) []Artist {
 	var artists []Artist
-	stmt, err := cp.db.Prepare(`SELECT DISTINCT(artist) FROM albums WHERE
-	  artist LIKE ? AND artist <> ""`)
-	if err != nil {
-		return artists
+	var rows *sql.Rows
+	var err error
+	if match := ftsQuery(query); match != "" {
+		rows, err = cp.db.Query(`SELECT artist FROM albums_fts
+		  WHERE albums_fts MATCH ? AND artist <> ""
+		  GROUP BY artist ORDER BY MIN(rank)`, "artist : ("+match+")")
+	} else {
+		rows, err = cp.db.Query(`SELECT DISTINCT(artist) FROM albums
+		  WHERE artist <> "" ORDER BY artist`)
 	}
-	defer stmt.Close()
-	rows, err := stmt.Query(fmt.Sprintf("%%%s%%", query))
 	if err != nil {
 		return artists
 	}
@@ -308,7 +386,7 @@ func (cp *ContentProvider) FindAlbumsByArtistName(artist string) []Album {
 
 func (cp *ContentProvider) FindTracksByArtist(artist, album string) []Track {
 	var tracks []Track
-	stmt, err := cp.db.Prepare(`SELECT id, nid, title, album, albumId, artist, duration FROM tracks WHERE artist = ? AND album LIKE ?`)
+	stmt, err := cp.db.Prepare(`SELECT ` + trackColumns + ` FROM tracks WHERE artist = ? AND album LIKE ?`)
 	if err != nil {
 		return tracks
 	}
@@ -319,8 +397,10 @@ func (cp *ContentProvider) FindTracksByArtist(artist, album string) []Track {
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var track Track
-		rows.Scan(&track.ID, &track.Nid, &track.Title, &track.Album, &track.AlbumID, &track.Artist, &track.DurationMillis)
+		track, err := scanTrack(rows)
+		if err != nil {
+			return tracks
+		}
 		tracks = append(tracks, track)
 	}
 
@@ -0,0 +1,21 @@
+package contentprovider
+
+// RadioTracks returns up to n tracks from the radio station seeded from a
+// track, leaving out recently played ones. They're cached, so that they can
+// be found by ID.
+func (cp *ContentProvider) RadioTracks(seedTrackID string, n int, recentlyPlayed []string) ([]Track, error) {
+	gpmTracks, err := cp.gpm.RadioStationTracks(seedTrackID, n, recentlyPlayed)
+	if err != nil {
+		return nil, err
+	}
+	var tracks = make([]Track, len(gpmTracks))
+	for i, track := range gpmTracks {
+		tracks[i] = Track(track)
+	}
+	err = cp.store.persistTracks(tracks)
+	if err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
@@ -0,0 +1,63 @@
+package contentprovider
+
+import (
+	"database/sql"
+)
+
+// sqlAddReplayGain stores tracks' ReplayGain values, in dB and linear peak.
+var sqlAddReplayGain []string = []string{
+	`ALTER TABLE tracks ADD COLUMN trackGain REAL`,
+	`ALTER TABLE tracks ADD COLUMN trackPeak REAL`,
+	`ALTER TABLE tracks ADD COLUMN albumGain REAL`,
+	`ALTER TABLE tracks ADD COLUMN albumPeak REAL`,
+}
+
+// ReplayGain holds a track's ReplayGain values. Gains are in dB, peaks are
+// linear, 1.0 being full scale. A zero peak is unknown.
+type ReplayGain struct {
+	TrackGain float64
+	TrackPeak float64
+	AlbumGain float64
+	AlbumPeak float64
+	HasTrack  bool // is TrackGain known?
+	HasAlbum  bool // is AlbumGain known?
+}
+
+// ReplayGain returns the cached ReplayGain values of a track.
+func (cp *ContentProvider) ReplayGain(trackID string) (ReplayGain, error) {
+	var rg ReplayGain
+	var trackGain, trackPeak, albumGain, albumPeak sql.NullFloat64
+	err := cp.db.QueryRow(`SELECT trackGain, trackPeak, albumGain, albumPeak
+	  FROM tracks WHERE id = ?`, trackID).Scan(&trackGain, &trackPeak,
+		&albumGain, &albumPeak)
+	if err != nil {
+		return rg, err
+	}
+
+	rg.TrackGain, rg.HasTrack = trackGain.Float64, trackGain.Valid
+	rg.AlbumGain, rg.HasAlbum = albumGain.Float64, albumGain.Valid
+	rg.TrackPeak = trackPeak.Float64
+	rg.AlbumPeak = albumPeak.Float64
+
+	return rg, nil
+}
+
+// SetReplayGain caches the known ReplayGain values of a track, keeping
+// those already cached for the others.
+func (cp *ContentProvider) SetReplayGain(trackID string, rg ReplayGain) error {
+	var trackGain, trackPeak, albumGain, albumPeak sql.NullFloat64
+	if rg.HasTrack {
+		trackGain = sql.NullFloat64{Float64: rg.TrackGain, Valid: true}
+		trackPeak = sql.NullFloat64{Float64: rg.TrackPeak, Valid: rg.TrackPeak > 0}
+	}
+	if rg.HasAlbum {
+		albumGain = sql.NullFloat64{Float64: rg.AlbumGain, Valid: true}
+		albumPeak = sql.NullFloat64{Float64: rg.AlbumPeak, Valid: rg.AlbumPeak > 0}
+	}
+	_, err := cp.db.Exec(`UPDATE tracks SET
+	  trackGain = COALESCE(?, trackGain), trackPeak = COALESCE(?, trackPeak),
+	  albumGain = COALESCE(?, albumGain), albumPeak = COALESCE(?, albumPeak)
+	  WHERE id = ?`, trackGain, trackPeak, albumGain, albumPeak, trackID)
+
+	return err
+}
@@ -0,0 +1,87 @@
+package contentprovider
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ftsQuery turns free-form user input into an FTS5 query expression,
+// matching every word of input as a prefix. It returns an empty string
+// when input contains no searchable words.
+func ftsQuery(input string) string {
+	words := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// searchLocalTracks returns up to limit cached tracks matching query, best
+// matches first.
+func (cp *ContentProvider) searchLocalTracks(query string, limit int) ([]Track, error) {
+	var tracks []Track
+	match := ftsQuery(query)
+	if match == "" {
+		return tracks, nil
+	}
+	rows, err := cp.db.Query(`SELECT `+trackColumns+`
+      FROM tracks_fts JOIN tracks ON tracks.id = tracks_fts.id
+      WHERE tracks_fts MATCH ? ORDER BY tracks_fts.rank LIMIT ?`,
+		match, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// SearchTracks searches cached tracks, ranked by relevance, and only asks
+// the service when fewer than minResults tracks are found locally. Remote
+// results are appended after local ones.
+func (cp *ContentProvider) SearchTracks(query string, minResults int) ([]Track, error) {
+	tracks, err := cp.searchLocalTracks(query, 200)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) >= minResults {
+		return tracks, nil
+	}
+
+	remote, err := cp.FindTracks(query)
+	if err != nil {
+		if len(tracks) > 0 {
+			return tracks, nil
+		}
+		return nil, err
+	}
+
+	return mergeTracks(tracks, remote), nil
+}
+
+// mergeTracks appends the remote tracks not in local to local. Tracks are
+// told apart by their key, as tracks outside the library have no ID.
+func mergeTracks(local, remote []Track) []Track {
+	seen := make(map[string]bool, len(local))
+	for _, t := range local {
+		seen[t.key()] = true
+	}
+	for _, t := range remote {
+		if !seen[t.key()] {
+			seen[t.key()] = true
+			local = append(local, t)
+		}
+	}
+
+	return local
+}
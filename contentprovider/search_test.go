@@ -0,0 +1,58 @@
+package contentprovider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"Comfortably Numb", `"Comfortably"* "Numb"*`},
+		{"  sigur rós ", `"sigur"* "rós"*`},
+		{`AC/DC "live"`, `"AC"* "DC"* "live"*`},
+		{" - ", ""},
+	}
+	for _, test := range tests {
+		if got := ftsQuery(test.input); got != test.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMergeTracksWithoutIDs(t *testing.T) {
+	s := newTestStore(t)
+	cp := &ContentProvider{db: s.db, store: s}
+	cached := []Track{
+		{ID: "uuid1", Nid: "Tnid1", Title: "Numb", Album: "A", Artist: "B", AlbumID: "Bal"},
+		{Nid: "Tnid2", Title: "Numb Again", Album: "A", Artist: "B", AlbumID: "Bal"},
+	}
+	if err := s.persistTracks(cached); err != nil {
+		t.Fatal(err)
+	}
+	local, err := cp.searchLocalTracks("numb", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 2 {
+		t.Fatalf("searchLocalTracks() = %d tracks, want 2", len(local))
+	}
+
+	remote := []Track{
+		{Nid: "Tnid2", Title: "Numb Again"},
+		{Nid: "Tnid3", Title: "Numb Three"},
+		{Nid: "Tnid4", Title: "Numb Four"},
+		{Nid: "Tnid3", Title: "Numb Three"},
+	}
+	merged := mergeTracks(local, remote)
+	var got []string
+	for _, track := range merged[len(local):] {
+		got = append(got, track.key())
+	}
+	// Local hits come first, in rank order, then the remote tracks not
+	// found locally.
+	if want := []string{"Tnid3", "Tnid4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeTracks() appended %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package contentprovider
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// sqlCreateStickers stores stickers, clients' data attached to songs, and
+// caches tracks' ratings.
+var sqlCreateStickers []string = []string{
+	`CREATE TABLE stickers (
+    type VARCHAR(255) NOT NULL,
+    uri VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    PRIMARY KEY(type, uri, name))`,
+	`ALTER TABLE tracks ADD COLUMN rating VARCHAR(8) NOT NULL DEFAULT ''`,
+}
+
+// Google Play Music track ratings.
+const (
+	RatingNone      = "0"
+	RatingThumbDown = "1"
+	RatingThumbUp   = "5"
+)
+
+var ErrNoSticker = errors.New("no such sticker")
+
+// Sticker is a named value attached to an object, a song's URI.
+type Sticker struct {
+	URI   string
+	Name  string
+	Value string
+}
+
+// Sticker returns the value of an object's sticker, or ErrNoSticker.
+func (cp *ContentProvider) Sticker(typ, uri, name string) (string, error) {
+	var value string
+	err := cp.db.QueryRow(`SELECT value FROM stickers
+	  WHERE type = ? AND uri = ? AND name = ?`, typ, uri, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSticker
+	}
+
+	return value, err
+}
+
+// SetSticker sets the value of an object's sticker.
+func (cp *ContentProvider) SetSticker(typ, uri, name, value string) error {
+	_, err := cp.db.Exec(`INSERT INTO stickers(type, uri, name, value)
+	  VALUES (?, ?, ?, ?)
+	  ON CONFLICT(type, uri, name) DO UPDATE SET value = excluded.value`,
+		typ, uri, name, value)
+
+	return err
+}
+
+// DeleteSticker deletes an object's sticker, or all of them if name is
+// empty. It returns ErrNoSticker if there was none.
+func (cp *ContentProvider) DeleteSticker(typ, uri, name string) error {
+	var result sql.Result
+	var err error
+	if name == "" {
+		result, err = cp.db.Exec(`DELETE FROM stickers
+		  WHERE type = ? AND uri = ?`, typ, uri)
+	} else {
+		result, err = cp.db.Exec(`DELETE FROM stickers
+		  WHERE type = ? AND uri = ? AND name = ?`, typ, uri, name)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNoSticker
+	}
+
+	return nil
+}
+
+// Stickers returns an object's stickers, by name.
+func (cp *ContentProvider) Stickers(typ, uri string) ([]Sticker, error) {
+	return cp.queryStickers(`SELECT uri, name, value FROM stickers
+	  WHERE type = ? AND uri = ? ORDER BY name`, typ, uri)
+}
+
+// FindStickers returns the stickers named name, of objects of a type.
+func (cp *ContentProvider) FindStickers(typ, name string) ([]Sticker, error) {
+	return cp.queryStickers(`SELECT uri, name, value FROM stickers
+	  WHERE type = ? AND name = ? ORDER BY uri`, typ, name)
+}
+
+// queryStickers returns the stickers a query selects.
+func (cp *ContentProvider) queryStickers(query string, args ...interface{}) ([]Sticker, error) {
+	rows, err := cp.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stickers []Sticker
+	for rows.Next() {
+		var s Sticker
+		err = rows.Scan(&s.URI, &s.Name, &s.Value)
+		if err != nil {
+			return nil, err
+		}
+		stickers = append(stickers, s)
+	}
+
+	return stickers, rows.Err()
+}
+
+// RateTrack rates a track on Google Play Music, and in the cache, where
+// it's found by its ID or its store ID.
+func (cp *ContentProvider) RateTrack(trackID, rating string) error {
+	err := cp.gpm.RateTrack(trackID, rating)
+	if err != nil {
+		return err
+	}
+	_, err = cp.db.Exec(`UPDATE tracks SET rating = ? WHERE id = ? OR nid = ?`,
+		rating, trackID, trackID)
+
+	return err
+}
+
+// RatedTracks returns the ratings of cached tracks rated thumbs up or down,
+// by track ID.
+func (cp *ContentProvider) RatedTracks() (map[string]string, error) {
+	rows, err := cp.db.Query(`SELECT id, rating FROM tracks
+	  WHERE rating IN (?, ?)`, RatingThumbDown, RatingThumbUp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ratings := make(map[string]string)
+	for rows.Next() {
+		var id, rating string
+		err = rows.Scan(&id, &rating)
+		if err != nil {
+			return nil, err
+		}
+		ratings[id] = rating
+	}
+
+	return ratings, rows.Err()
+}
@@ -0,0 +1,199 @@
+package contentprovider
+
+import (
+	"database/sql"
+)
+
+// trackColumns are the columns scanTrack reads a track from.
+const trackColumns = `tracks.id, tracks.nid, tracks.title, tracks.album,
+      tracks.albumId, tracks.artist, tracks.duration, tracks.albumArtist,
+      tracks.genre, tracks.trackNumber, tracks.discNumber, tracks.year,
+      tracks.rating`
+
+// sqlDropUnkeyedTracks drops the store tracks cached under an empty ID,
+// which overwrote each other; they are cached again by key when found.
+var sqlDropUnkeyedTracks []string = []string{
+	`DELETE FROM tracks WHERE id = ''`,
+	`DELETE FROM tracks_fts WHERE id = ''`,
+}
+
+// store is the cache's data-access layer. It prepares its statements once,
+// and runs bulk writes in a single transaction.
+type store struct {
+	db *sql.DB
+
+	upsertTrack    *sql.Stmt
+	selectTrack    *sql.Stmt
+	deleteTrack    *sql.Stmt
+	deleteTrackFts *sql.Stmt
+	insertTrackFts *sql.Stmt
+	upsertAlbum    *sql.Stmt
+	deleteAlbumFts *sql.Stmt
+	insertAlbumFts *sql.Stmt
+}
+
+// sqlStatements maps a store's statements to their SQL.
+func (s *store) sqlStatements() map[**sql.Stmt]string {
+	return map[**sql.Stmt]string{
+		// A track found by a search must not drop out of the library, so
+		// inLibrary only ever changes to inLibrary here. Search results
+		// don't carry ratings either, so an empty one keeps the cached one.
+		&s.upsertTrack: `
+	  INSERT INTO tracks(id, nid, title, album, artist, albumId, duration,
+	    albumArtist, genre, trackNumber, discNumber, year, rating, inLibrary)
+	  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	  ON CONFLICT(id) DO UPDATE SET
+	    nid = excluded.nid, title = excluded.title, album = excluded.album,
+	    artist = excluded.artist, albumId = excluded.albumId,
+	    duration = excluded.duration, albumArtist = excluded.albumArtist,
+	    genre = excluded.genre, trackNumber = excluded.trackNumber,
+	    discNumber = excluded.discNumber, year = excluded.year,
+	    rating = CASE WHEN excluded.rating <> '' THEN excluded.rating ELSE rating END,
+	    inLibrary = CASE WHEN excluded.inLibrary = 1 THEN 1 ELSE inLibrary END`,
+		&s.selectTrack:    `SELECT ` + trackColumns + ` FROM tracks WHERE id = ?`,
+		&s.deleteTrack:    "DELETE FROM tracks WHERE id = ?",
+		&s.deleteTrackFts: "DELETE FROM tracks_fts WHERE id = ?",
+		&s.insertTrackFts: "INSERT INTO tracks_fts(title, album, artist, id) VALUES (?, ?, ?, ?)",
+		&s.upsertAlbum: `
+	  INSERT INTO albums(id, name, artist, year) VALUES (?, ?, ?, ?)
+	  ON CONFLICT(id) DO UPDATE SET
+	    name = excluded.name, artist = excluded.artist, year = excluded.year`,
+		&s.deleteAlbumFts: "DELETE FROM albums_fts WHERE id = ?",
+		&s.insertAlbumFts: "INSERT INTO albums_fts(name, artist, id) VALUES (?, ?, ?)",
+	}
+}
+
+// newStore allocates a new store, preparing its statements on db.
+func newStore(db *sql.DB) (*store, error) {
+	s := &store{db: db}
+	for stmt, query := range s.sqlStatements() {
+		var err error
+		*stmt, err = db.Prepare(query)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// close releases the store's prepared statements.
+func (s *store) close() {
+	for stmt := range s.sqlStatements() {
+		if *stmt != nil {
+			(*stmt).Close()
+		}
+	}
+}
+
+// inTx runs f in a transaction, committing it unless f fails.
+func (s *store) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// persistTracks inserts or updates tracks in a single transaction.
+func (s *store) persistTracks(tracks []Track) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.persistTracksTx(tx, tracks, notInLibrary)
+	})
+}
+
+// persistTracksTx inserts or updates tracks, and their search index
+// entries, within tx, each under its key.
+func (s *store) persistTracksTx(tx *sql.Tx, tracks []Track, library int) error {
+	upsert := tx.Stmt(s.upsertTrack)
+	deleteFts := tx.Stmt(s.deleteTrackFts)
+	insertFts := tx.Stmt(s.insertTrackFts)
+	for _, track := range tracks {
+		_, err := upsert.Exec(track.key(), track.Nid, track.Title, track.Album,
+			track.Artist, track.AlbumID, track.DurationMillis, track.AlbumArtist,
+			track.Genre, track.TrackNumber, track.DiscNumber, track.Year,
+			track.Rating, library)
+		if err != nil {
+			return err
+		}
+		_, err = deleteFts.Exec(track.key())
+		if err != nil {
+			return err
+		}
+		_, err = insertFts.Exec(track.Title, track.Album, track.Artist, track.key())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteTrackTx removes a track, and its search index entry, within tx.
+func (s *store) deleteTrackTx(tx *sql.Tx, trackID string) error {
+	_, err := tx.Stmt(s.deleteTrack).Exec(trackID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Stmt(s.deleteTrackFts).Exec(trackID)
+
+	return err
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTrack reads a track from a row of trackColumns.
+func scanTrack(row scanner) (Track, error) {
+	var track Track
+	err := row.Scan(&track.ID, &track.Nid, &track.Title, &track.Album,
+		&track.AlbumID, &track.Artist, &track.DurationMillis,
+		&track.AlbumArtist, &track.Genre, &track.TrackNumber,
+		&track.DiscNumber, &track.Year, &track.Rating)
+	// Tracks outside the library are keyed on their Nid, having no ID.
+	if track.ID == track.Nid {
+		track.ID = ""
+	}
+
+	return track, err
+}
+
+// retrieveTrack returns a cached track, or sql.ErrNoRows if there is none.
+func (s *store) retrieveTrack(trackID string) (Track, error) {
+	return scanTrack(s.selectTrack.QueryRow(trackID))
+}
+
+// persistAlbums inserts or updates albums, and their search index entries,
+// in a single transaction.
+func (s *store) persistAlbums(albums []Album) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		upsert := tx.Stmt(s.upsertAlbum)
+		deleteFts := tx.Stmt(s.deleteAlbumFts)
+		insertFts := tx.Stmt(s.insertAlbumFts)
+		for _, album := range albums {
+			_, err := upsert.Exec(album.ID, album.Name, album.Artist, album.Year)
+			if err != nil {
+				return err
+			}
+			_, err = deleteFts.Exec(album.ID)
+			if err != nil {
+				return err
+			}
+			_, err = insertFts.Exec(album.Name, album.Artist, album.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
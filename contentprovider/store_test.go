@@ -0,0 +1,56 @@
+package contentprovider
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// newTestStore returns a store on an empty, migrated in-memory database.
+func newTestStore(t *testing.T) *store {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection to :memory: opens a database of its own.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	err = migrateSqliteDatabase(db)
+	if err != nil && strings.Contains(err.Error(), "fts5") {
+		t.Skip("sqlite3 built without FTS5, use -tags sqlite_fts5")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.close)
+
+	return s
+}
+
+func TestPersistTracksKeysStoreTracksOnNid(t *testing.T) {
+	s := newTestStore(t)
+	tracks := []Track{
+		{Nid: "Tnid1", Title: "One", Album: "A", Artist: "B", AlbumID: "Bal"},
+		{Nid: "Tnid2", Title: "Two", Album: "A", Artist: "B", AlbumID: "Bal"},
+		{ID: "uuid3", Nid: "Tnid3", Title: "Three", Album: "A", Artist: "B", AlbumID: "Bal"},
+	}
+	if err := s.persistTracks(tracks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range tracks {
+		got, err := s.retrieveTrack(want.key())
+		if err != nil {
+			t.Errorf("retrieveTrack(%q): %s", want.key(), err)
+			continue
+		}
+		if got.ID != want.ID || got.Nid != want.Nid || got.Title != want.Title {
+			t.Errorf("retrieveTrack(%q) = %q/%q %q, want %q/%q %q", want.key(),
+				got.ID, got.Nid, got.Title, want.ID, want.Nid, want.Title)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package contentprovider
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// streamURLExpiryMargin is how long before its expiry a cached URL is
+	// considered stale, so the player never starts on a dying URL.
+	streamURLExpiryMargin = 30 * time.Second
+	// streamURLDefaultTTL is used when a URL carries no expire parameter.
+	streamURLDefaultTTL = time.Minute
+)
+
+// streamURL is a resolved, signed stream URL and its expiry time.
+type streamURL struct {
+	url     string
+	expires time.Time
+}
+
+// StreamURLCacheStats holds stream URL cache metrics.
+type StreamURLCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Entries       int
+}
+
+// HitRate returns the ratio of cache hits to lookups.
+func (s StreamURLCacheStats) HitRate() float64 {
+	lookups := s.Hits + s.Misses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(lookups)
+}
+
+// streamURLCache caches resolved stream URLs by track ID until they expire.
+type streamURLCache struct {
+	sync.Mutex
+	urls  map[string]streamURL
+	stats StreamURLCacheStats
+	now   func() time.Time
+}
+
+// newStreamURLCache allocates a new streamURLCache.
+func newStreamURLCache() *streamURLCache {
+	return &streamURLCache{
+		urls: make(map[string]streamURL),
+		now:  time.Now,
+	}
+}
+
+// get returns the cached URL for track, if there is a fresh one.
+func (c *streamURLCache) get(track string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	s, ok := c.urls[track]
+	if ok && c.now().Add(streamURLExpiryMargin).Before(s.expires) {
+		c.stats.Hits++
+		return s.url, true
+	}
+	if ok {
+		delete(c.urls, track)
+	}
+	c.stats.Misses++
+
+	return "", false
+}
+
+// put caches rawurl for track, until the expiry signed into it.
+func (c *streamURLCache) put(track, rawurl string) {
+	c.Lock()
+	defer c.Unlock()
+
+	expires, ok := parseStreamURLExpiry(rawurl)
+	if !ok {
+		expires = c.now().Add(streamURLDefaultTTL)
+	}
+	c.urls[track] = streamURL{url: rawurl, expires: expires}
+}
+
+// invalidate drops the cached URL for track.
+func (c *streamURLCache) invalidate(track string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.urls[track]; ok {
+		delete(c.urls, track)
+		c.stats.Invalidations++
+	}
+}
+
+// snapshot returns current cache metrics.
+func (c *streamURLCache) snapshot() StreamURLCacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.urls)
+	return stats
+}
+
+// parseStreamURLExpiry returns the expiry time signed into a stream URL's
+// expire parameter, in seconds since the epoch.
+func parseStreamURLExpiry(rawurl string) (time.Time, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expire, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(expire, 0), true
+}
@@ -0,0 +1,53 @@
+package contentprovider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStreamURLExpiry(t *testing.T) {
+	expires, ok := parseStreamURLExpiry("https://example.com/videoplayback?id=a&expire=1400000000&signature=b")
+	if !ok {
+		t.Fatal("expiry not found")
+	}
+	if expires.Unix() != 1400000000 {
+		t.Errorf("Expiry = %d, want %d", expires.Unix(), 1400000000)
+	}
+
+	if _, ok := parseStreamURLExpiry("https://example.com/videoplayback?id=a"); ok {
+		t.Error("expiry found in URL without expire parameter")
+	}
+}
+
+func TestStreamURLCacheExpiry(t *testing.T) {
+	now := time.Unix(1400000000, 0)
+	c := newStreamURLCache()
+	c.now = func() time.Time { return now }
+
+	c.put("t1", "https://example.com/?expire=1400000600")
+	if url, ok := c.get("t1"); !ok || url != "https://example.com/?expire=1400000600" {
+		t.Errorf("Get = %q, %v, want cached URL", url, ok)
+	}
+
+	now = now.Add(590 * time.Second)
+	if _, ok := c.get("t1"); ok {
+		t.Error("URL about to expire was served from cache")
+	}
+
+	stats := c.snapshot()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 0 {
+		t.Errorf("Stats = %+v, want 1 hit, 1 miss, 0 entries", stats)
+	}
+}
+
+func TestStreamURLCacheInvalidate(t *testing.T) {
+	c := newStreamURLCache()
+	c.put("t1", "https://example.com/")
+	c.invalidate("t1")
+	if _, ok := c.get("t1"); ok {
+		t.Error("invalidated URL was served from cache")
+	}
+	if c.snapshot().Invalidations != 1 {
+		t.Errorf("Invalidations = %d, want 1", c.snapshot().Invalidations)
+	}
+}
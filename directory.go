@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	cp "github.com/amir/gmpd/contentprovider"
+)
+
+// Virtual directory tree, as browsed by lsinfo and friends:
+//
+//	Library/Artists/<artist>/<album>/
+//	Library/Tracks/
+//	Playlists/
+//	Search/<query>/
+const (
+	dirLibrary   = "Library"
+	dirArtists   = "Library/Artists"
+	dirTracks    = "Library/Tracks"
+	dirPlaylists = "Playlists"
+	dirSearch    = "Search"
+)
+
+var errNoSuchDirectory = errors.New("directory or file does not exist")
+
+// pathEscaper escapes path separators in directory names.
+var pathEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// pathUnescaper reverses pathEscaper.
+var pathUnescaper = strings.NewReplacer("%2F", "/", "%25", "%")
+
+// dirEntry is an entry of a virtual directory: a subdirectory, a playlist,
+// or a track.
+type dirEntry struct {
+	directory string    // path of a subdirectory
+	playlist  string    // name of a playlist
+	track     *cp.Track // a track
+}
+
+// joinPath appends an escaped name to a virtual directory path.
+func joinPath(dir, name string) string {
+	return dir + "/" + pathEscaper.Replace(name)
+}
+
+// splitPath returns the unescaped elements of a virtual directory path.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	elements := strings.Split(path, "/")
+	for i, e := range elements {
+		elements[i] = pathUnescaper.Replace(e)
+	}
+
+	return elements
+}
+
+// trackEntries returns dirEntries of tracks.
+func trackEntries(tracks []cp.Track) []dirEntry {
+	entries := make([]dirEntry, len(tracks))
+	for i := range tracks {
+		entries[i].track = &tracks[i]
+	}
+
+	return entries
+}
+
+// directoryEntries returns dirEntries of subdirectories of dir.
+func directoryEntries(dir string, names []string) []dirEntry {
+	entries := make([]dirEntry, len(names))
+	for i, name := range names {
+		entries[i].directory = joinPath(dir, name)
+	}
+
+	return entries
+}
+
+// listDirectory returns the entries of the virtual directory at path. A
+// track ID lists the track itself.
+func listDirectory(path string) ([]dirEntry, error) {
+	elements := splitPath(path)
+	switch {
+	case len(elements) == 0:
+		return []dirEntry{{directory: dirLibrary}, {directory: dirPlaylists},
+			{directory: dirSearch}}, nil
+
+	case elements[0] == dirLibrary && len(elements) == 1:
+		return []dirEntry{{directory: dirArtists}, {directory: dirTracks}}, nil
+
+	case elements[0] == dirLibrary && elements[1] == "Artists":
+		dir := dirArtists
+		switch len(elements) {
+		case 2:
+			artists, err := daemon.cp.LibraryArtists()
+			return directoryEntries(dir, artists), err
+		case 3:
+			albums, err := daemon.cp.LibraryAlbums(elements[2])
+			return directoryEntries(joinPath(dir, elements[2]), albums), err
+		case 4:
+			tracks, err := daemon.cp.LibraryTracks(elements[2], elements[3])
+			return trackEntries(tracks), err
+		}
+
+	case elements[0] == dirLibrary && elements[1] == "Tracks" && len(elements) == 2:
+		tracks, err := daemon.cp.UserTracks()
+		return trackEntries(tracks), err
+
+	case elements[0] == dirPlaylists && len(elements) == 1:
+		playlists, err := daemon.cp.Playlists()
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]dirEntry, len(playlists))
+		for i, playlist := range playlists {
+			entries[i].playlist = playlist.Name
+		}
+		return entries, nil
+
+	case elements[0] == dirSearch && len(elements) == 1:
+		return nil, nil
+
+	case elements[0] == dirSearch && len(elements) == 2:
+		tracks, err := daemon.cp.SearchTracks(elements[1], searchMinResults)
+		return trackEntries(tracks), err
+
+	case len(elements) == 1:
+		track, err := findTrack(elements[0])
+		if err != nil {
+			return nil, errNoSuchDirectory
+		}
+		return []dirEntry{{track: &track}}, nil
+	}
+
+	return nil, errNoSuchDirectory
+}
+
+// walkDirectory calls f for every entry below the virtual directory at
+// path, recursively. Search results and the flat track list are not
+// walked into, as they can't be enumerated or repeat the artists tree.
+func walkDirectory(path string, f func(e dirEntry)) error {
+	entries, err := listDirectory(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		f(e)
+		if e.directory == "" || e.directory == dirSearch || e.directory == dirTracks {
+			continue
+		}
+		err = walkDirectory(e.directory, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeEntry writes an MPD-response-formatted entry, with its metadata when
+// info is set.
+func writeEntry(w io.Writer, e dirEntry, info bool) {
+	switch {
+	case e.directory != "":
+		fmt.Fprintf(w, "directory: %s\n", e.directory)
+	case e.playlist != "":
+		fmt.Fprintf(w, "playlist: %s\n", e.playlist)
+	case info:
+		fmt.Fprintf(w, "%s", e.track)
+	default:
+		fmt.Fprintf(w, "file: %s\n", trackFile(*e.track))
+	}
+}
+
+// trackFile returns the file name clients use to refer to track.
+func trackFile(track cp.Track) string {
+	if track.ID == "" {
+		return track.Nid
+	}
+	return track.ID
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventsPath is the path of the HTTP API's WebSocket event stream.
+	eventsPath = "/events"
+	// eventsPingInterval is how often idle event streams are pinged, to
+	// keep them from timing out.
+	eventsPingInterval = 30 * time.Second
+	// eventsWriteTimeout is how long writing an event may take before the
+	// client is given up on.
+	eventsWriteTimeout = 10 * time.Second
+)
+
+var eventsUpgrader = websocket.Upgrader{}
+
+// event is a change pushed to event stream clients, as JSON.
+type event struct {
+	Type    string                 `json:"type"` // track, state, queue, volume, error or library
+	Song    map[string]interface{} `json:"song,omitempty"`
+	State   string                 `json:"state,omitempty"`
+	Version *int                   `json:"version,omitempty"`
+	Length  *int                   `json:"length,omitempty"`
+	Volume  *int                   `json:"volume,omitempty"`
+	Error   *string                `json:"error,omitempty"`
+}
+
+// playerSnapshot is what event streams tell clients about a player, to
+// push events only when it changes.
+type playerSnapshot struct {
+	songID    int
+	state     string
+	volume    int
+	lastError string
+}
+
+// snapshot returns the snapshot of part's player.
+func snapshot(part *partition) playerSnapshot {
+	ps := playerSnapshot{
+		songID:    -1,
+		state:     part.player.state(),
+		volume:    part.player.volume,
+		lastError: part.player.lastError,
+	}
+	if item, err := part.playlist.ItemAtPosition(part.playlist.Position); err == nil && ps.state != "stop" {
+		ps.songID = item.ID
+	}
+
+	return ps
+}
+
+// events returns the events of subsystems changing in part, since it was
+// last.
+func events(part *partition, last *playerSnapshot, subsystems []string) []event {
+	var events []event
+	for _, subsystem := range subsystems {
+		switch subsystem {
+		case IdlePlayer:
+			current := snapshot(part)
+			if current.songID != last.songID && current.songID != -1 {
+				var song bytes.Buffer
+				e := event{Type: "track"}
+				if writeQueueItem(&song, part.playlist, part.playlist.Position) == nil {
+					e.Song = parseResponse(song.Bytes(), true)[0]
+				}
+				events = append(events, e)
+			}
+			if current.state != last.state {
+				events = append(events, event{Type: "state", State: current.state})
+			}
+			if current.lastError != last.lastError {
+				events = append(events, event{Type: "error", Error: &current.lastError})
+			}
+			last.songID, last.state, last.lastError = current.songID, current.state, current.lastError
+		case IdlePlaylist:
+			version, length := part.playlist.Version, part.playlist.Len()
+			events = append(events, event{Type: "queue", Version: &version, Length: &length})
+		case IdleMixer:
+			volume := part.player.volume
+			if volume != last.volume {
+				events = append(events, event{Type: "volume", Volume: &volume})
+				last.volume = volume
+			}
+		case IdleDatabase:
+			events = append(events, event{Type: "library"})
+		}
+	}
+
+	return events
+}
+
+// serveEvents streams events of the partition given as partition, or the
+// default one, over a WebSocket. Clients need the read permission, either
+// by default, or granted by a password given as a bearer token, or as
+// password, as browsers can't set headers on WebSockets.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeHTTPError(w, AckErrorArg)
+		return
+	}
+	daemon.Lock()
+	s, ackError := httpSession(r)
+	if ackError == 0 && !s.allowed("status") {
+		ackError = AckErrorPermission
+	}
+	daemon.Unlock()
+	if ackError > 0 {
+		writeHTTPError(w, ackError)
+		return
+	}
+
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	daemon.sessions.add(s)
+	defer daemon.sessions.remove(s)
+
+	closed := make(chan struct{})
+	go func() {
+		// Read until the client goes, handling its control messages.
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	daemon.Lock()
+	last := snapshot(s.partition)
+	daemon.Unlock()
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout))
+			if err != nil {
+				return
+			}
+		case <-s.wake:
+			daemon.Lock()
+			pending := events(s.partition, &last, s.changes())
+			daemon.Unlock()
+			for _, e := range pending {
+				conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+				if err := conn.WriteJSON(e); err != nil {
+					log.Printf("Event stream: %s", err)
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	cp "github.com/amir/gmpd/contentprovider"
+	"github.com/amir/gmpd/util"
+)
+
+var errBadFilter = errors.New("bad tag filter")
+
+// trackTags maps MPD tag names, lower-cased, to a track's value of the tag.
+var trackTags = map[string]func(t cp.Track) string{
+	"file":        trackFile,
+	"artist":      func(t cp.Track) string { return t.Artist },
+	"album":       func(t cp.Track) string { return t.Album },
+	"albumartist": func(t cp.Track) string { return t.AlbumArtist },
+	"title":       func(t cp.Track) string { return t.Title },
+	"genre":       func(t cp.Track) string { return t.Genre },
+	"date":        func(t cp.Track) string { return itoaNonZero(t.Year) },
+	"track":       func(t cp.Track) string { return itoaNonZero(t.TrackNumber) },
+	"disc":        func(t cp.Track) string { return itoaNonZero(t.DiscNumber) },
+}
+
+// tagTypes are the tags tracks have, as MPD names them.
+var tagTypes = []string{"Artist", "Album", "AlbumArtist", "Title", "Track",
+	"Genre", "Date", "Disc"}
+
+// itoaNonZero formats n, or returns an empty string if it's zero.
+func itoaNonZero(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// tagFilter matches tracks whose tag has value. The any tag matches every
+// tag.
+type tagFilter struct {
+	tag   string
+	value string
+}
+
+// parseTagFilters parses the remaining TAG VALUE pairs of a command.
+func parseTagFilters(tok *util.Tokenizer) ([]tagFilter, error) {
+	var filters []tagFilter
+	for tag := tok.NextParam(); tag != ""; tag = tok.NextParam() {
+		tag = strings.ToLower(tag)
+		if _, ok := trackTags[tag]; !ok && tag != "any" {
+			return nil, errBadFilter
+		}
+		filters = append(filters, tagFilter{tag, tok.NextParam()})
+	}
+	if len(filters) == 0 {
+		return nil, errBadFilter
+	}
+
+	return filters, nil
+}
+
+// matchTrack reports whether track matches every filter. Values must be
+// equal, or unless exact is set, contain the filter's value ignoring case.
+func matchTrack(track cp.Track, filters []tagFilter, exact bool) bool {
+	for _, f := range filters {
+		value := strings.ToLower(f.value)
+		match := func(tag string) bool {
+			v := trackTags[tag](track)
+			if exact {
+				return v == f.value
+			}
+			return strings.Contains(strings.ToLower(v), value)
+		}
+
+		matched := false
+		if f.tag == "any" {
+			for tag := range trackTags {
+				if match(tag) {
+					matched = true
+					break
+				}
+			}
+		} else {
+			matched = match(f.tag)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
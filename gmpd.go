@@ -7,23 +7,34 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	cp "github.com/amir/gmpd/contentprovider"
+	"github.com/amir/gmpd/queue"
+	"github.com/amir/gmpd/scrobbler"
 	"github.com/amir/gmpd/util"
-	"github.com/amir/gst"
 	"github.com/ziutek/glib"
 )
 
 const mpdVersion = "0.17.0"
 
+// previousRestartThreshold is the elapsed time past which previous restarts
+// the current track rather than going back to the previous one.
+const previousRestartThreshold = 3 * time.Second
+
+// searchMinResults is the number of cached results below which search also
+// queries Google Play Music.
+const searchMinResults = 20
+
 // MPD ACK_ERRORs
 const (
 	AckErrorNotList    = 1
@@ -48,12 +59,6 @@ const (
 	ClientListModeEnd     = "command_list_end"
 )
 
-// Playlist represents player's current playlist.
-type Playlist struct {
-	tracks   []string // track IDs
-	position int      // current position
-}
-
 // commandList represents daemon's commands queue.
 type commandList struct {
 	commands []string // list of commands
@@ -61,142 +66,60 @@ type commandList struct {
 	okMode   bool     // should print a list_OK after each commands output
 }
 
-// gmpd represents a google MPD.
-type gmpd struct {
-	cp          *cp.ContentProvider // Proxies (and caches) Google Play Music WS calls
-	playlist    *Playlist           // daemon's playlist
-	startTime   int64               // when daemon started
-	commandList *commandList        // daemon's queued commands list
+// options represents playback options set by clients.
+type options struct {
+	replayGainMode string  // one of replayGainModes
+	crossfade      int     // crossfade duration, in seconds
+	mixRampDB      float64 // MixRamp threshold, in dB
+	mixRampDelay   float64 // MixRamp delay, in seconds, NaN when off
 }
 
-// Player represents a GStreamer playbin for playing music.
-type Player struct {
-	pipe *gst.Element
-	bus  *gst.Bus
+// gmpd represents a google MPD.
+type gmpd struct {
+	sync.Mutex // guards playback state against concurrent clients and the player
+
+	cp          *cp.ContentProvider  // Proxies (and caches) Google Play Music WS calls
+	partitions  partitions           // playlists and players, the default first
+	startTime   int64                // when daemon started
+	commandList *commandList         // daemon's queued commands list
+	sessions    *sessions            // connected clients
+	updater     *updater             // library synchronisation jobs
+	scrobbler   *scrobbler.Scrobbler // nil unless scrobbling is enabled
+
+	defaultPermissions int            // permissions of clients without a password
+	passwords          map[string]int // permissions granted by password
 }
 
 var (
 	daemon *gmpd
-	player *Player
 
-	serviceAddress = flag.String("address", ":6600", "gMPD service address")
-	email          = flag.String("email", "email", "Google account email")
-	password       = flag.String("password", "password", "Google account password")
-	cacheDir       = flag.String("cache-dir", "", "Cache directory")
+	serviceAddress  = flag.String("address", ":6600", "gMPD service address")
+	email           = flag.String("email", "email", "Google account email")
+	password        = flag.String("password", "password", "Google account password")
+	cacheDir        = flag.String("cache-dir", "", "Cache directory")
+	syncInterval    = flag.Duration("sync-interval", 30*time.Minute, "Library synchronisation interval")
+	maxPlayFailures = flag.Int("max-play-failures", 3, "Failures to play a track before skipping it")
+	outputValues    outputFlags
+	passwordValues  passwordFlags
+
+	defaultPermissions = flag.String("default-permissions", "read,add,control,admin",
+		"Permissions of clients without a password")
+	httpAddress  = flag.String("http-address", "", "HTTP JSON API address, off if empty")
+	mprisEnabled = flag.Bool("mpris", false, "Export the default partition's player over MPRIS on the session bus")
+
+	replayGainPreamp = flag.Float64("replaygain-preamp", 0, "ReplayGain preamp, in dB")
+	replayGainLimit  = flag.Bool("replaygain-limit", true, "Prevent clipping when applying ReplayGain")
+
+	lastFM               = flag.Bool("lastfm", false, "Scrobble to Last.fm")
+	lastFMEndpoint       = flag.String("lastfm-endpoint", scrobbler.LastFMEndpoint, "Last.fm API endpoint")
+	lastFMAPIKey         = flag.String("lastfm-api-key", "", "Last.fm API key")
+	lastFMSecret         = flag.String("lastfm-secret", "", "Last.fm API shared secret")
+	lastFMSessionKey     = flag.String("lastfm-session-key", "", "Last.fm session key")
+	listenBrainz         = flag.Bool("listenbrainz", false, "Submit listens to ListenBrainz")
+	listenBrainzEndpoint = flag.String("listenbrainz-endpoint", scrobbler.ListenBrainzEndpoint, "ListenBrainz API root")
+	listenBrainzToken    = flag.String("listenbrainz-token", "", "ListenBrainz user token")
 )
 
-// onMessage is GStreamer's playbin bus message callback.
-func (p *Player) onMessage(bus *gst.Bus, msg *gst.Message) {
-	switch msg.GetType() {
-	case gst.MESSAGE_EOS:
-		p.pipe.SetState(gst.STATE_NULL)
-		daemon.playlist.playNext()
-	case gst.MESSAGE_ERROR:
-		p.pipe.SetState(gst.STATE_NULL)
-		err, debug := msg.ParseError()
-		fmt.Printf("Error: %s (debug: %s)\n", err, debug)
-	}
-}
-
-// onSyncMessage is GStreamer's playbin bus sync element callback.
-func (p *Player) onSyncMessage(bus *gst.Bus, msg *gst.Message) {
-}
-
-// play sets GStreamer pipe's URI prorperty, and set the state to play.
-func (p *Player) play(url string) {
-	p.pipe.SetProperty("uri", url)
-	p.pipe.SetState(gst.STATE_PLAYING)
-}
-
-// pause pauses player if its playing.
-func (p *Player) pause() {
-	state, _, _ := p.pipe.GetState(gst.CLOCK_TIME_NONE)
-	if state == gst.STATE_PLAYING {
-		p.pipe.SetState(gst.STATE_PAUSED)
-	}
-}
-
-// stop stops player.
-func (p *Player) stop() {
-	p.pipe.SetState(gst.STATE_NULL)
-}
-
-// state reports player's state.
-func (p *Player) state() string {
-	state, _, _ := p.pipe.GetState(gst.CLOCK_TIME_NONE)
-	switch state {
-	case gst.STATE_PLAYING:
-		return "play"
-	default:
-		return "stop"
-	}
-}
-
-// trackPosition returns track's position in playlist
-func (p *Playlist) trackPosition(track string) int {
-	index := -1
-	for i, s := range p.tracks {
-		if s == track {
-			index = i
-		}
-	}
-
-	return index
-}
-
-// String returns MPD-response-formatted representation of the playlist
-func (p *Playlist) String() string {
-	buffer := bytes.NewBufferString("")
-
-	for p, t := range p.tracks {
-		fmt.Fprintf(buffer, "%d:file: %s\n", p, t)
-	}
-
-	return buffer.String()
-}
-
-// trackAtPosition returns track ID at provided position in playlist
-func (p *Playlist) trackAtPosition(pos int) (track string, err error) {
-	if pos >= 0 && pos < p.length() {
-		return p.tracks[pos], nil
-	}
-	return "", errors.New("track does not exist")
-}
-
-// currentTrack returns current track in playlist
-func (p *Playlist) currentTrack() (tack string, err error) {
-	if p.length() > 0 {
-		return p.tracks[p.position], nil
-	}
-
-	return "", errors.New("playlist is empty")
-}
-
-// playNext plays the next track in playlist
-func (p *Playlist) playNext() {
-	if p.position < p.length() {
-		track, err := p.trackAtPosition(p.position + 1)
-		if err == nil {
-			url, err := daemon.cp.TrackStreamURL(track)
-			if err == nil {
-				player.play(url)
-				p.position = p.position + 1
-			}
-		}
-	}
-}
-
-// addTrack adds a new track to playlist
-func (p *Playlist) addTrack(track string) int {
-	p.tracks = append(p.tracks, track)
-	return p.length() - 1
-}
-
-// length returns number of tracks in playlist
-func (p *Playlist) length() int {
-	return len(p.tracks)
-}
-
 // being begins consuming, and populating commands
 func (c *commandList) begin(okMode bool) {
 	var commands []string
@@ -211,10 +134,10 @@ func (c *commandList) add(command string) {
 }
 
 // process process all commands in command list
-func (c *commandList) process() []byte {
+func (c *commandList) process(s *session) []byte {
 	var response []byte
-	for _, s := range c.commands {
-		r, ackError := processCommand(s)
+	for _, command := range c.commands {
+		r, ackError := processCommand(s, command)
 		if ackError > 0 {
 			break
 		}
@@ -232,37 +155,78 @@ func (c *commandList) reset() {
 	c.active = false
 }
 
-// processCommand process MPD commands, and responds to them
-func processCommand(commandString string) ([]byte, int) {
+// processCommand process MPD commands from session s, and responds to them
+func processCommand(s *session, commandString string) ([]byte, int) {
 	ackError := 0
 	var responseBuffer bytes.Buffer
 	response := bufio.NewWriter(&responseBuffer)
 
+	part := s.partition
+	playlist := part.playlist
+	player := part.player
+
 	tok := util.NewTokenizer(commandString)
 	command := tok.NextParam()
+	if !s.allowed(command) {
+		return nil, AckErrorPermission
+	}
 	switch command {
+	case "password":
+		if s.authenticate(tok.NextParam()) != nil {
+			ackError = AckErrorPassword
+		}
+
 	case "add":
 		songID := tok.NextParam()
-		daemon.playlist.addTrack(songID)
+		playlist.Add(songID)
+		part.notify(IdlePlaylist)
 	case "addid":
 		songID := tok.NextParam()
-		fmt.Fprintf(response, "Id: %d\n", daemon.playlist.addTrack(songID))
-
-	case "playlistfind":
-		tok.NextParam()
-		filename := tok.NextParam()
-		pos := daemon.playlist.trackPosition(filename)
-		if pos > -1 {
-			track, err := daemon.cp.FindTrack(filename)
-			if err != nil {
-				ackError = AckErrorNoExist
-			} else {
-				fmt.Fprintf(response, "%s", track)
-				fmt.Fprintf(response, "Pos: %d\n", pos)
-				fmt.Fprintf(response, "Id: %d\n", pos)
+		pos := playlist.Len()
+		if param := tok.NextParam(); param != "" {
+			var err error
+			pos, err = strconv.Atoi(param)
+			if err != nil || pos < 0 || pos > playlist.Len() {
+				ackError = AckErrorArg
+				break
 			}
-		} else {
+		}
+		fmt.Fprintf(response, "Id: %d\n", playlist.Insert(songID, pos))
+		part.notify(IdlePlaylist)
+
+	case "deleteid":
+		id, err := strconv.Atoi(tok.NextParam())
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		pos := playlist.IDPosition(id)
+		if pos == -1 {
 			ackError = AckErrorNoExist
+			break
+		}
+		if pos == playlist.Position && player.state() != "stop" {
+			// Move on to the next track, like at the end of this one.
+			if next := playlist.NextPosition(); next == pos || playlist.playPosition(next) != nil {
+				player.stop()
+			}
+			pos = playlist.IDPosition(id)
+			part.notify(IdlePlayer)
+		}
+		playlist.Remove(pos)
+		part.notify(IdlePlaylist)
+
+	case "playlistfind", "playlistsearch":
+		filters, err := parseTagFilters(tok)
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		for pos, item := range playlist.Items {
+			track, err := findTrack(item.Track)
+			if err == nil && matchTrack(track, filters, command == "playlistfind") {
+				writeQueueItem(response, playlist, pos)
+			}
 		}
 
 	case "commands":
@@ -271,72 +235,254 @@ func processCommand(commandString string) ([]byte, int) {
 	case "notcommands":
 		fmt.Fprintf(response, "%s", util.MPDNotSupportedCommands())
 
-	case "playid":
-		pos, err := strconv.Atoi(tok.NextParam())
-		if err == nil && pos <= daemon.playlist.length() {
-			filename, err := daemon.playlist.trackAtPosition(pos)
-			if err == nil {
-				url, err := daemon.cp.TrackStreamURL(filename)
-				if err != nil {
+	case "play", "playid":
+		param := tok.NextParam()
+		if param == "" {
+			switch player.state() {
+			case "pause":
+				player.resume()
+			case "stop":
+				if playlist.playPosition(playlist.Position) != nil {
 					ackError = AckErrorNoExist
-				} else {
-					player.play(url)
 				}
-			} else {
-				ackError = AckErrorNoExist
 			}
+			part.notify(IdlePlayer)
+			break
+		}
+		pos, err := strconv.Atoi(param)
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		if command == "playid" {
+			pos = playlist.IDPosition(pos)
+		}
+		if playlist.playPosition(pos) != nil {
+			ackError = AckErrorNoExist
+			break
 		}
+		part.notify(IdlePlayer)
+
+	case "next":
+		if player.state() == "stop" {
+			break
+		}
+		if playlist.advance(playlist.NextPosition()) != nil {
+			player.stop()
+		}
+		part.notify(IdlePlayer)
+
+	case "previous":
+		if player.state() == "stop" {
+			break
+		}
+		ok, elapsed := player.position()
+		pos := playlist.Position - 1
+		if ok && time.Duration(elapsed) > previousRestartThreshold || pos < 0 {
+			pos = playlist.Position
+		}
+		playlist.playPosition(pos)
+		part.notify(IdlePlayer)
+
+	case "seekcur":
+		param := tok.NextParam()
+		seconds, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		if player.state() == "stop" {
+			ackError = AckErrorPlayerSync
+			break
+		}
+		pos := time.Duration(seconds * float64(time.Second))
+		if strings.HasPrefix(param, "+") || strings.HasPrefix(param, "-") {
+			_, elapsed := player.position()
+			pos += time.Duration(elapsed)
+		}
+		if pos < 0 {
+			pos = 0
+		}
+		player.seek(pos)
+		part.notify(IdlePlayer)
 
 	case "stop":
 		player.stop()
+		part.notify(IdlePlayer)
 
 	case "pause":
-		player.pause()
+		switch tok.NextParam() {
+		case "1":
+			player.pause()
+		case "0":
+			player.resume()
+		default:
+			if player.state() == "pause" {
+				player.resume()
+			} else {
+				player.pause()
+			}
+		}
+		part.notify(IdlePlayer)
 
 	case "playlist":
-		fmt.Fprintf(response, "%s", daemon.playlist)
+		fmt.Fprintf(response, "%s", playlist)
 
 	case "playlistinfo":
-		fallthrough
+		start, end := 0, playlist.Len()
+		if param := tok.NextParam(); param != "" {
+			var err error
+			start, end, err = util.ParseRange(param, playlist.Len())
+			if err != nil || start >= playlist.Len() {
+				ackError = AckErrorArg
+				break
+			}
+			if end > playlist.Len() {
+				end = playlist.Len()
+			}
+		}
+		for pos := start; pos < end; pos++ {
+			writeQueueItem(response, playlist, pos)
+		}
+
 	case "playlistid":
-		id, err := strconv.Atoi(tok.NextParam())
+		param := tok.NextParam()
+		if param == "" {
+			for pos := range playlist.Items {
+				writeQueueItem(response, playlist, pos)
+			}
+			break
+		}
+		id, err := strconv.Atoi(param)
 		if err != nil {
-			ackError = AckErrorNoExist
+			ackError = AckErrorArg
 			break
 		}
+		if writeQueueItem(response, playlist, playlist.IDPosition(id)) != nil {
+			ackError = AckErrorNoExist
+		}
 
-		filename, err := daemon.playlist.trackAtPosition(id)
+	case "plchanges", "plchangesposid":
+		version, err := strconv.Atoi(tok.NextParam())
 		if err != nil {
-			ackError = AckErrorNoExist
+			ackError = AckErrorArg
 			break
 		}
+		for _, pos := range playlist.ChangesSince(version) {
+			if command == "plchangesposid" {
+				fmt.Fprintf(response, "cpos: %d\nId: %d\n", pos, playlist.Items[pos].ID)
+			} else {
+				writeQueueItem(response, playlist, pos)
+			}
+		}
 
-		track, err := daemon.cp.FindTrack(filename)
-		if err != nil {
-			ackError = AckErrorNoExist
-		} else {
-			fmt.Fprintf(response, "%s", track)
-			fmt.Fprintf(response, "Pos: %d\n", id)
-			fmt.Fprintf(response, "Id: %d\n", id)
+	case "prio", "prioid":
+		prio, err := strconv.Atoi(tok.NextParam())
+		if err != nil || prio < 0 || prio > queue.MaxPriority {
+			ackError = AckErrorArg
+			break
+		}
+		var positions []int
+		for param := tok.NextParam(); param != ""; param = tok.NextParam() {
+			if command == "prioid" {
+				id, err := strconv.Atoi(param)
+				pos := playlist.IDPosition(id)
+				if err != nil || pos == -1 {
+					ackError = AckErrorNoExist
+					break
+				}
+				positions = append(positions, pos)
+				continue
+			}
+			start, end, err := util.ParseRange(param, playlist.Len())
+			if err != nil || end > playlist.Len() {
+				ackError = AckErrorArg
+				break
+			}
+			for pos := start; pos < end; pos++ {
+				positions = append(positions, pos)
+			}
+		}
+		if ackError > 0 {
+			break
+		}
+		for _, pos := range positions {
+			playlist.SetPriority(pos, prio)
+		}
+		part.notify(IdlePlaylist)
+
+	case "random":
+		switch tok.NextParam() {
+		case "0":
+			playlist.SetRandom(false)
+		case "1":
+			playlist.SetRandom(true)
+		default:
+			ackError = AckErrorArg
+		}
+		if ackError == 0 {
+			player.applyReplayGain()
+			part.notify(IdleOptions)
+		}
+
+	case "repeat", "single", "consume":
+		options := map[string]*bool{
+			"repeat":  &playlist.Repeat,
+			"single":  &playlist.Single,
+			"consume": &playlist.Consume,
+		}
+		switch tok.NextParam() {
+		case "0":
+			*options[command] = false
+		case "1":
+			*options[command] = true
+		default:
+			ackError = AckErrorArg
+		}
+		if ackError == 0 {
+			part.notify(IdleOptions)
+		}
+
+	case "setvol":
+		volume, err := strconv.Atoi(tok.NextParam())
+		if err != nil || volume < 0 || volume > 100 {
+			ackError = AckErrorArg
+			break
+		}
+		player.setVolume(volume)
+		part.notify(IdleMixer)
+
+	case "getvol":
+		fmt.Fprintf(response, "volume: %d\n", player.volume)
+
+	case "clearerror":
+		player.lastError = ""
+		part.notify(IdlePlayer)
+
+	case "radio":
+		switch tok.NextParam() {
+		case "0":
+			part.radio.enabled = false
+			part.radio.waiting = false
+		case "1":
+			part.radio.enabled = true
+		default:
+			ackError = AckErrorArg
+		}
+		if ackError == 0 {
+			part.notify(IdleOptions)
 		}
 
 	case "status":
-		response.Write([]byte("playlist: 0\n"))
-		fmt.Fprintf(response, "playlistlength: %d\n", daemon.playlist.length())
-		state := player.state()
-		if state == "play" {
-			response.Write([]byte("state: " + state + "\n"))
-			fmt.Fprintf(response, "song: %d\n", daemon.playlist.position)
-			fmt.Fprintf(response, "songid: %d\n", daemon.playlist.position)
-			ok, pos := player.pipe.GetPosition()
-			if ok {
-				pos = pos / 1000000000
-				fmt.Fprintf(response, "elapsed: %d.00\n", pos)
-				fmt.Fprintf(response, "time: %d:00\n", pos)
-			}
-		} else {
-			response.Write([]byte("state: " + state + "\n"))
+		writeStatus(response, part)
+
+	case "update", "rescan":
+		job, err := daemon.updater.start(command == "rescan")
+		if err != nil {
+			ackError = AckErrorUpdateAlready
+			break
 		}
+		fmt.Fprintf(response, "updating_db: %d\n", job)
 
 	case "search":
 		var queryBuffer bytes.Buffer
@@ -349,7 +495,7 @@ func processCommand(commandString string) ([]byte, int) {
 		}
 		query = queryBuffer.String()
 
-		tracks, err := daemon.cp.FindTracks(query)
+		tracks, err := daemon.cp.SearchTracks(query, searchMinResults)
 		if err != nil {
 			break
 		}
@@ -363,9 +509,9 @@ func processCommand(commandString string) ([]byte, int) {
 		switch queryType {
 		case "album":
 			album, err := daemon.cp.FindAlbum(query, true)
-			if err != nil {
+			if err == nil {
 				for _, track := range album.Tracks {
-					fmt.Fprintf(response, "%s", track)
+					fmt.Fprintf(response, "%s", cp.Track(track))
 				}
 			}
 		case "artist":
@@ -378,22 +524,140 @@ func processCommand(commandString string) ([]byte, int) {
 		}
 
 	case "outputs":
-		response.Write([]byte("outputid: 0\n"))
-		response.Write([]byte("outputname: My Pulse Output\no"))
-		response.Write([]byte("outputenabled: 1\n"))
+		writeOutputs(response, part.outputs)
+
+	case "enableoutput", "disableoutput", "toggleoutput":
+		output, err := findOutput(part.outputs, tok.NextParam())
+		if err != nil {
+			ackError = AckErrorNoExist
+			break
+		}
+		switch command {
+		case "enableoutput":
+			output.enabled = true
+		case "disableoutput":
+			output.enabled = false
+		default:
+			output.enabled = !output.enabled
+		}
+		part.setOutputs()
+
+	case "replay_gain_mode":
+		mode := tok.NextParam()
+		if !replayGainModes[mode] {
+			ackError = AckErrorArg
+			break
+		}
+		part.options.replayGainMode = mode
+		player.setReplayGainMode(mode)
+		part.notify(IdleOptions)
+
+	case "crossfade":
+		seconds, err := strconv.Atoi(tok.NextParam())
+		if err != nil || seconds < 0 {
+			ackError = AckErrorArg
+			break
+		}
+		part.options.crossfade = seconds
+		part.notify(IdleOptions)
+
+	case "mixrampdb":
+		db, err := strconv.ParseFloat(tok.NextParam(), 64)
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		part.options.mixRampDB = db
+		part.notify(IdleOptions)
+
+	case "mixrampdelay":
+		// Tracks carry no MixRamp tags, so like MPD for untagged songs,
+		// transitions fall back to crossfading.
+		delay, err := strconv.ParseFloat(tok.NextParam(), 64)
+		if err != nil {
+			ackError = AckErrorArg
+			break
+		}
+		if delay < 0 {
+			delay = math.NaN()
+		}
+		part.options.mixRampDelay = delay
+		part.notify(IdleOptions)
+
+	case "replay_gain_status":
+		fmt.Fprintf(response, "replay_gain_mode: %s\n", part.options.replayGainMode)
+
+	case "outputset":
+		output, err := findOutput(part.outputs, tok.NextParam())
+		if err != nil {
+			ackError = AckErrorNoExist
+			break
+		}
+		name := tok.NextParam()
+		if !runtimeAttributes[name] {
+			ackError = AckErrorArg
+			break
+		}
+		output.attributes[name] = tok.NextParam()
+		if output.enabled {
+			player.setOutputs(part.outputs)
+		}
+		part.notify(IdleOutput)
 
 	case "stats":
 		now := time.Now()
+		library, err := daemon.cp.LibraryStats()
+		if err != nil {
+			ackError = AckErrorSystem
+			break
+		}
+		fmt.Fprintf(response, "artists: %d\n", library.Artists)
+		fmt.Fprintf(response, "albums: %d\n", library.Albums)
+		fmt.Fprintf(response, "songs: %d\n", library.Songs)
 		response.Write([]byte("uptime: " +
 			strconv.FormatInt(now.Unix()-daemon.startTime, 10) + "\n"))
+		fmt.Fprintf(response, "db_playtime: %d\n", int64(library.Playtime/time.Second))
+		if lastSync := daemon.cp.LastSync(); !lastSync.IsZero() {
+			fmt.Fprintf(response, "db_update: %d\n", lastSync.Unix())
+		}
+		fmt.Fprintf(response, "playtime: %d\n", int64(daemon.partitions.playtime()/time.Second))
+		if history, err := daemon.cp.HistoryPlayStats(); err == nil {
+			fmt.Fprintf(response, "plays: %d\n", history.Plays)
+			fmt.Fprintf(response, "played_songs: %d\n", history.Tracks)
+			fmt.Fprintf(response, "listened: %d\n", int64(history.Listened/time.Second))
+		}
 
 	case "lsinfo":
-		tracks, err := daemon.cp.UserTracks()
+		entries, err := listDirectory(tok.NextParam())
 		if err != nil {
-			log.Fatal(err)
+			ackError = AckErrorNoExist
+			break
 		}
-		for _, track := range tracks {
-			fmt.Fprintf(response, "%s", track)
+		for _, e := range entries {
+			writeEntry(response, e, true)
+		}
+
+	case "listall", "listallinfo":
+		err := walkDirectory(tok.NextParam(), func(e dirEntry) {
+			writeEntry(response, e, command == "listallinfo")
+		})
+		if err != nil {
+			ackError = AckErrorNoExist
+		}
+
+	case "listfiles":
+		entries, err := listDirectory(tok.NextParam())
+		if err != nil {
+			ackError = AckErrorNoExist
+			break
+		}
+		for _, e := range entries {
+			if e.directory != "" {
+				elements := splitPath(e.directory)
+				fmt.Fprintf(response, "directory: %s\n", elements[len(elements)-1])
+			} else if e.track != nil {
+				fmt.Fprintf(response, "file: %s\n", trackFile(*e.track))
+			}
 		}
 
 	case "list":
@@ -452,20 +716,93 @@ func processCommand(commandString string) ([]byte, int) {
 
 	case "currentsong":
 		state := player.state()
-		if state != "play" {
+		if state == "stop" {
 			break
 		}
-		filename, err := daemon.playlist.currentTrack()
-		track, err := daemon.cp.FindTrack(filename)
+		if writeQueueItem(response, playlist, playlist.Position) != nil {
+			ackError = AckErrorNoExist
+		}
+
+	case "urlhandlers":
+		fmt.Fprintf(response, "handler: %s\n", toneScheme)
+
+	case "sticker":
+		ackError = processSticker(tok, response)
+
+	case "subscribe":
+		switch s.subscribe(tok.NextParam()) {
+		case nil:
+			daemon.sessions.notify(IdleSubscription)
+		case errSubscribed:
+			ackError = AckErrorExist
+		default:
+			ackError = AckErrorArg
+		}
+
+	case "unsubscribe":
+		if s.unsubscribe(tok.NextParam()) != nil {
+			ackError = AckErrorNoExist
+			break
+		}
+		daemon.sessions.notify(IdleSubscription)
+
+	case "channels":
+		for _, channel := range daemon.sessions.channels() {
+			fmt.Fprintf(response, "channel: %s\n", channel)
+		}
+
+	case "readmessages":
+		s.readMessages(response)
+
+	case "sendmessage":
+		switch daemon.sessions.send(tok.NextParam(), tok.NextParam()) {
+		case nil:
+		case errNoSubscribers:
+			ackError = AckErrorNoExist
+		default:
+			ackError = AckErrorArg
+		}
+
+	case "partition":
+		to, err := daemon.partitions.find(tok.NextParam())
 		if err != nil {
 			ackError = AckErrorNoExist
 			break
 		}
-		fmt.Fprintf(response, "%s", track)
+		s.partition = to
+
+	case "listpartitions":
+		writePartitions(response, daemon.partitions)
+
+	case "newpartition":
+		switch _, err := daemon.partitions.add(tok.NextParam()); err {
+		case nil:
+			daemon.sessions.notify(IdlePartition)
+		case errPartitionExists:
+			ackError = AckErrorExist
+		default:
+			ackError = AckErrorArg
+		}
+
+	case "delpartition":
+		switch daemon.partitions.remove(tok.NextParam()) {
+		case nil:
+			daemon.sessions.notify(IdlePartition)
+		case errNoSuchPartition:
+			ackError = AckErrorNoExist
+		default:
+			ackError = AckErrorArg
+		}
+
+	case "moveoutput":
+		if daemon.partitions.moveOutput(tok.NextParam(), part) != nil {
+			ackError = AckErrorNoExist
+		}
 
-	case "urlhandlers":
-		fallthrough
 	case "tagtypes":
+		for _, tag := range tagTypes {
+			fmt.Fprintf(response, "tagtype: %s\n", tag)
+		}
 
 	default:
 		ackError = AckErrorUnknown
@@ -475,23 +812,79 @@ func processCommand(commandString string) ([]byte, int) {
 	return responseBuffer.Bytes(), ackError
 }
 
+// writeQueueItem writes MPD-response-formatted representation of the track
+// at pos in playlist
+func writeQueueItem(w io.Writer, playlist *Playlist, pos int) error {
+	item, err := playlist.ItemAtPosition(pos)
+	if err != nil {
+		return err
+	}
+	track, err := findTrack(item.Track)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s", track)
+	fmt.Fprintf(w, "Pos: %d\n", pos)
+	fmt.Fprintf(w, "Id: %d\n", item.ID)
+	if item.Prio > 0 {
+		fmt.Fprintf(w, "Prio: %d\n", item.Prio)
+	}
+
+	return nil
+}
+
 // handleMessage handles incoming messages from clients
 func handleMessage(client net.Conn) {
-	b := bufio.NewReader(client)
+	defer client.Close()
+	daemon.Lock()
+	s := newSession(daemon.partitions[0])
+	daemon.Unlock()
+	daemon.sessions.add(s)
+	defer daemon.sessions.remove(s)
+
+	lines := make(chan string)
+	go func() {
+		b := bufio.NewReader(client)
+		for {
+			line, err := b.ReadBytes('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimSpace(string(line))
+		}
+	}()
+
 	for {
-		line, err := b.ReadBytes('\n')
-		if err != nil {
-			break
+		var commandString string
+		select {
+		case <-s.wake:
+			s.flushIdle(client, false)
+			continue
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			commandString = line
 		}
 		ackError := 0
 		response := []byte("")
-		commandString := strings.TrimSpace(string(line))
 		tok := util.NewTokenizer(commandString)
 		command := tok.NextParam()
 
+		if command == "noidle" {
+			s.flushIdle(client, true)
+			continue
+		} else if s.idling {
+			continue
+		}
+
 		if daemon.commandList.active == true {
 			if command == ClientListModeEnd {
-				response = daemon.commandList.process()
+				daemon.Lock()
+				response = daemon.commandList.process(s)
+				daemon.Unlock()
 				daemon.commandList.reset()
 			} else {
 				daemon.commandList.add(commandString)
@@ -501,8 +894,18 @@ func handleMessage(client net.Conn) {
 				daemon.commandList.begin(false)
 			} else if command == ClientListOkModeBegin {
 				daemon.commandList.begin(true)
+			} else if command == "idle" {
+				var subsystems []string
+				for subsystem := tok.NextParam(); subsystem != ""; subsystem = tok.NextParam() {
+					subsystems = append(subsystems, subsystem)
+				}
+				s.idle(subsystems)
+				s.flushIdle(client, false)
+				continue
 			} else {
-				response, ackError = processCommand(commandString)
+				daemon.Lock()
+				response, ackError = processCommand(s, commandString)
+				daemon.Unlock()
 			}
 		}
 
@@ -522,31 +925,34 @@ func handleMessage(client net.Conn) {
 	}
 }
 
-// NewPlayer allocates a new Player.
-func NewPlayer() *Player {
-	p := new(Player)
-
-	p.pipe = gst.ElementFactoryMake("playbin2", "autoplay")
-	p.bus = p.pipe.GetBus()
-	p.bus.AddSignalWatch()
-	p.bus.Connect("message", (*Player).onMessage, p)
-	p.bus.EnableSyncMessageEmission()
-	p.bus.Connect("sync-message::element", (*Player).onSyncMessage, p)
-
-	return p
-}
-
 // NewGmpd allocates a new gmpd.
 func NewGmpd() *gmpd {
 	contentProvider, err := cp.New(*email, *password, *cacheDir)
 	if err != nil {
 		log.Fatal(err)
 	}
+	scrobbles, err := newScrobbler()
+	if err != nil {
+		log.Fatal(err)
+	}
+	permissions, err := parsePermissions(*defaultPermissions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwords, err := parsePasswords(passwordValues)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	return &gmpd{
 		cp:          contentProvider,
-		playlist:    new(Playlist),
 		commandList: new(commandList),
+		sessions:    new(sessions),
+		updater:     new(updater),
+		scrobbler:   scrobbles,
+
+		defaultPermissions: permissions,
+		passwords:          passwords,
 	}
 }
 
@@ -563,16 +969,23 @@ func mpdListener() {
 		conn.Write([]byte("OK MPD " + mpdVersion + "\n"))
 		go handleMessage(conn)
 	}
-	listener.Close()
 }
 
 func init() {
+	flag.Var(&outputValues, "output",
+		"Audio output, as plugin[,name=NAME][,enabled=0][,ATTRIBUTE=VALUE...] (repeatable)")
+	flag.Var(&passwordValues, "mpd-password",
+		"Password granting permissions, as PASSWORD@PERMISSION[,PERMISSION...] (repeatable)")
 	flag.Parse()
 	if *cacheDir == "" {
 		*cacheDir = util.CacheDir()
 	}
 	daemon = NewGmpd()
-	player = NewPlayer()
+	outputs, err := newOutputs(outputValues)
+	if err != nil {
+		log.Fatal(err)
+	}
+	daemon.partitions = partitions{newPartition(defaultPartition, outputs)}
 
 	now := time.Now()
 	daemon.startTime = now.Unix()
@@ -580,5 +993,15 @@ func init() {
 
 func main() {
 	go mpdListener()
+	if *httpAddress != "" {
+		go httpAPIListener()
+	}
+	if *mprisEnabled {
+		go mprisService()
+	}
+	go daemon.updater.periodic(*syncInterval)
+	if daemon.scrobbler != nil {
+		go daemon.scrobbler.Run(scrobbleRetryInterval)
+	}
 	glib.NewMainLoop(nil).Run()
 }
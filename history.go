@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A play counts once half the track, or maxListenRequired of it, was
+// listened to.
+const maxListenRequired = 4 * time.Minute
+
+// listen is a track being listened to.
+type listen struct {
+	track    string
+	start    time.Time     // when it started playing
+	listened time.Duration // furthest position it played to
+}
+
+// significantListen reports whether listening to a track lasting duration
+// for listened counts as a play. Without a duration, only
+// maxListenRequired does.
+func significantListen(listened, duration time.Duration) bool {
+	if duration > 0 && listened >= duration/2 {
+		return true
+	}
+	return listened >= maxListenRequired
+}
+
+// recordListen records l in the history, and scrobbles it, if it counts
+// as a play. Test tones are left out of the history.
+func recordListen(l listen) {
+	duration, _ := trackDuration(l.track)
+	if !significantListen(l.listened, duration) {
+		return
+	}
+	scrobble(l)
+	if strings.HasPrefix(l.track, toneScheme) {
+		return
+	}
+	go func() {
+		err := daemon.cp.RecordPlay(l.track, l.start, l.listened)
+		if err != nil {
+			log.Printf("Recording play of %s: %s", l.track, err)
+		}
+	}()
+}
+
+// trackDuration returns the duration of track, if it's known.
+func trackDuration(track string) (time.Duration, bool) {
+	t, err := findTrack(track)
+	if err != nil {
+		return 0, false
+	}
+	millis, err := strconv.Atoi(t.DurationMillis)
+	if err != nil || millis == 0 {
+		return 0, false
+	}
+
+	return time.Duration(millis) * time.Millisecond, true
+}
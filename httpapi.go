@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/amir/gmpd/util"
+)
+
+// httpAPIPrefix is the path the HTTP API's commands are found under, as
+// httpAPIPrefix + COMMAND.
+const httpAPIPrefix = "/api/"
+
+// httpUnsupported lists commands which only make sense on a connection, as
+// their state outlives a request.
+var httpUnsupported = map[string]bool{
+	"idle": true, "noidle": true, "password": true,
+	ClientListModeBegin: true, ClientListOkModeBegin: true, ClientListModeEnd: true,
+	"subscribe": true, "unsubscribe": true, "readmessages": true,
+}
+
+// httpObjectCommands lists commands answering with a single object, which
+// the API responds with as such rather than as an array.
+var httpObjectCommands = map[string]bool{
+	"status": true, "stats": true, "currentsong": true, "getvol": true,
+	"replay_gain_status": true, "addid": true, "update": true, "rescan": true,
+}
+
+// httpObjectKeys lists keys which start a new object in a response.
+var httpObjectKeys = map[string]bool{
+	"file": true, "directory": true, "playlist": true, "outputid": true,
+	"partition": true, "channel": true, "cpos": true,
+}
+
+// httpListKeys lists keys which may repeat within an object, and are
+// collected in an array.
+var httpListKeys = map[string]bool{"attribute": true, "sticker": true}
+
+// ackErrors describes ACK errors, with the HTTP status they map to.
+var ackErrors = map[int]struct {
+	message string
+	status  int
+}{
+	AckErrorArg:           {"bad argument", http.StatusBadRequest},
+	AckErrorPassword:      {"incorrect password", http.StatusUnauthorized},
+	AckErrorPermission:    {"permission denied", http.StatusForbidden},
+	AckErrorUnknown:       {"unknown command", http.StatusNotFound},
+	AckErrorNoExist:       {"no such object", http.StatusNotFound},
+	AckErrorSystem:        {"system error", http.StatusInternalServerError},
+	AckErrorUpdateAlready: {"already updating", http.StatusConflict},
+	AckErrorExist:         {"already exists", http.StatusConflict},
+}
+
+// httpAPI serves MPD commands as JSON over HTTP. A command is run as
+// GET or POST /api/COMMAND?arg=ARG&arg=..., by a session of its own with
+// the default permissions, or those of the password given as a bearer
+// token or as password, bound to the default partition, or the one given
+// as partition.
+// Commands needing more than the read permission are POST only.
+type httpAPI struct{}
+
+// ServeHTTP implements http.Handler.
+func (api httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	command := strings.TrimPrefix(r.URL.Path, httpAPIPrefix)
+	if command == "" || strings.Contains(command, "/") || httpUnsupported[command] {
+		writeHTTPError(w, AckErrorUnknown)
+		return
+	}
+	if r.Method != "POST" && (r.Method != "GET" || commandPermission(command)&^permissionRead != 0) {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeHTTPError(w, AckErrorArg)
+		return
+	}
+	commandString := command
+	for _, arg := range r.Form["arg"] {
+		commandString += " " + util.Quote(arg)
+	}
+
+	daemon.Lock()
+	s, ackError := httpSession(r)
+	var response []byte
+	if ackError == 0 {
+		response, ackError = processCommand(s, commandString)
+	}
+	daemon.Unlock()
+	if ackError > 0 {
+		writeHTTPError(w, ackError)
+		return
+	}
+
+	objects := parseResponse(response, httpObjectCommands[command])
+	var result interface{} = objects
+	if httpObjectCommands[command] {
+		result = map[string]interface{}{}
+		if len(objects) > 0 {
+			result = objects[0]
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// httpSession returns the session of the client making r, or the ACK
+// error of setting it up.
+func httpSession(r *http.Request) (*session, int) {
+	s := newSession(daemon.partitions[0])
+	password := r.Form.Get("password")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, AckErrorPassword
+		}
+		password = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if password != "" && s.authenticate(password) != nil {
+		return nil, AckErrorPassword
+	}
+	if name := r.Form.Get("partition"); name != "" {
+		part, err := daemon.partitions.find(name)
+		if err != nil {
+			return nil, AckErrorNoExist
+		}
+		s.partition = part
+	}
+
+	return s, 0
+}
+
+// parseResponse parses an MPD response into objects, mapping keys to
+// values, or to arrays of values for httpListKeys. A single response is
+// parsed into one object.
+func parseResponse(response []byte, single bool) []map[string]interface{} {
+	objects := []map[string]interface{}{}
+	var object map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		if httpListKeys[key] {
+			if object == nil {
+				object = make(map[string]interface{})
+				objects = append(objects, object)
+			}
+			values, _ := object[key].([]string)
+			object[key] = append(values, value)
+			continue
+		}
+		_, repeated := object[key]
+		if object == nil || !single && (repeated || httpObjectKeys[key] && len(object) > 0) {
+			object = make(map[string]interface{})
+			objects = append(objects, object)
+		}
+		object[key] = value
+	}
+
+	return objects
+}
+
+// writeJSON writes v as a JSON response with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ackMessage describes ackError.
+func ackMessage(ackError int) string {
+	if e, ok := ackErrors[ackError]; ok {
+		return e.message
+	}
+	return "command failed"
+}
+
+// writeHTTPError writes the JSON response of ackError.
+func writeHTTPError(w http.ResponseWriter, ackError int) {
+	e, ok := ackErrors[ackError]
+	if !ok {
+		e.message, e.status = ackMessage(ackError), http.StatusBadRequest
+	}
+	writeJSON(w, e.status, map[string]interface{}{
+		"error": e.message,
+		"ack":   ackError,
+	})
+}
+
+// httpAPIListener serves the HTTP API on httpAddress.
+func httpAPIListener() {
+	mux := http.NewServeMux()
+	mux.Handle(httpAPIPrefix, httpAPI{})
+	mux.HandleFunc(eventsPath, serveEvents)
+	log.Fatal(http.ListenAndServe(*httpAddress, mux))
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/amir/gmpd/util"
+	"github.com/amir/gst"
+)
+
+// icyMetaInterval is the number of audio bytes between ICY metadata blocks.
+const icyMetaInterval = 16000
+
+// listenerBacklog is the number of chunks a listener may fall behind before
+// it's disconnected.
+const listenerBacklog = 256
+
+// httpEncoder describes how an httpd output encodes audio.
+type httpEncoder struct {
+	elements    []string // encoder, and muxer if any
+	contentType string
+}
+
+// httpEncoders maps httpd output encoder attributes to their encoders.
+var httpEncoders = map[string]httpEncoder{
+	"vorbis": {[]string{"vorbisenc", "oggmux"}, "audio/ogg"},
+	"opus":   {[]string{"opusenc", "oggmux"}, "audio/ogg"},
+	"mp3":    {[]string{"lamemp3enc"}, "audio/mpeg"},
+}
+
+// httpStreamer serves an encoded audio stream to HTTP listeners. The
+// encoder writes into a pipe, which is broadcast to every listener. Ogg
+// streams are broadcast a page at a time, and their header pages are kept
+// to send to listeners joining later on, as they can't be decoded without.
+type httpStreamer struct {
+	sync.Mutex
+	contentType string
+	pipe        *os.File             // write end of the encoder's pipe
+	listeners   map[chan []byte]bool // chunks of audio, per listener
+	headers     [][]byte             // Ogg header pages of the current stream
+	title       string               // current ICY stream title
+}
+
+// newHTTPStreamer starts serving an encoded audio stream on addr.
+func newHTTPStreamer(addr, contentType string) (*httpStreamer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s := &httpStreamer{
+		contentType: contentType,
+		pipe:        w,
+		listeners:   make(map[chan []byte]bool),
+	}
+	go s.broadcast(r)
+	go http.Serve(ln, s)
+
+	return s, nil
+}
+
+// broadcast copies the encoded stream to every listener, disconnecting
+// those that fall behind.
+func (s *httpStreamer) broadcast(r io.Reader) {
+	br := bufio.NewReaderSize(r, util.OggMaxPageLength)
+	buf := make([]byte, 4096)
+	inHeaders := false
+	for {
+		var chunk []byte
+		ogg, restart := false, false
+		if magic, _ := br.Peek(4); string(magic) == "OggS" {
+			page, err := util.ReadOggPage(br)
+			if err != nil {
+				log.Printf("HTTP stream stopped: %s", err)
+				return
+			}
+			// A stream, chained after the last one on a pipeline rebuild,
+			// starts with its header pages, until audio has a position.
+			restart = page.BOS() && !inHeaders
+			inHeaders = page.BOS() || inHeaders && page.Granule() <= 0
+			chunk, ogg = page, true
+		} else {
+			n, err := br.Read(buf)
+			if err != nil {
+				log.Printf("HTTP stream stopped: %s", err)
+				return
+			}
+			chunk = make([]byte, n)
+			copy(chunk, buf[:n])
+			inHeaders = false
+		}
+
+		s.Lock()
+		if restart || !ogg {
+			s.headers = nil
+		}
+		if inHeaders {
+			s.headers = append(s.headers, chunk)
+		}
+		for l := range s.listeners {
+			select {
+			case l <- chunk:
+			default:
+				delete(s.listeners, l)
+				close(l)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// setTitle sets the stream title announced to ICY listeners.
+func (s *httpStreamer) setTitle(title string) {
+	s.Lock()
+	defer s.Unlock()
+	s.title = title
+}
+
+// currentTitle returns the stream title announced to ICY listeners.
+func (s *httpStreamer) currentTitle() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.title
+}
+
+// ServeHTTP streams audio to a listener, interleaved with ICY metadata if
+// it asks for it.
+func (s *httpStreamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	contentType := s.contentType
+	s.Unlock()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("icy-name", "gmpd")
+	var out io.Writer = w
+	if r.Header.Get("Icy-MetaData") == "1" {
+		w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInterval))
+		out = &icyWriter{w: w, title: s.currentTitle}
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+
+	l := make(chan []byte, listenerBacklog)
+	s.Lock()
+	s.listeners[l] = true
+	headers := s.headers
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		if s.listeners[l] {
+			delete(s.listeners, l)
+			close(l)
+		}
+		s.Unlock()
+	}()
+
+	for _, page := range headers {
+		if _, err := out.Write(page); err != nil {
+			return
+		}
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case chunk, ok := <-l:
+			if !ok {
+				return
+			}
+			if _, err := out.Write(chunk); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// icyWriter interleaves ICY metadata blocks into an audio stream, every
+// icyMetaInterval bytes.
+type icyWriter struct {
+	w     io.Writer
+	title func() string // returns the current stream title
+	sent  string        // last title sent
+	count int           // audio bytes since the last metadata block
+}
+
+// Write implements io.Writer.
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := icyMetaInterval - iw.count
+		if n > len(p) {
+			n = len(p)
+		}
+		m, err := iw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		iw.count += n
+
+		if iw.count == icyMetaInterval {
+			title := iw.title()
+			meta := []byte{0}
+			if title != iw.sent {
+				meta = util.IcyMetadata(title)
+				iw.sent = title
+			}
+			if _, err := iw.w.Write(meta); err != nil {
+				return written, err
+			}
+			iw.count = 0
+		}
+	}
+
+	return written, nil
+}
+
+// httpdSink makes an httpd output's chain of elements, encoding audio into
+// its streamer's pipe. The streamer is started the first time, and keeps
+// serving on the same address across pipeline rebuilds.
+func (o *audioOutput) httpdSink(prefix string) []*gst.Element {
+	encoder, ok := httpEncoders[o.attributes["encoder"]]
+	if !ok {
+		encoder = httpEncoders["vorbis"]
+	}
+	if o.streamer == nil {
+		port := o.attributes["port"]
+		if port == "" {
+			port = "8000"
+		}
+		addr := net.JoinHostPort(o.attributes["bind_to_address"], port)
+		streamer, err := newHTTPStreamer(addr, encoder.contentType)
+		if err != nil {
+			log.Printf("Output %q: %s", o.name, err)
+			return []*gst.Element{gst.ElementFactoryMake("fakesink", prefix+"sink")}
+		}
+		o.streamer = streamer
+	}
+	o.streamer.Lock()
+	o.streamer.contentType = encoder.contentType
+	o.streamer.Unlock()
+
+	elements := []*gst.Element{
+		gst.ElementFactoryMake("queue", prefix+"queue"),
+		gst.ElementFactoryMake("audioconvert", prefix+"convert"),
+		gst.ElementFactoryMake("audioresample", prefix+"resample"),
+	}
+	for i, name := range encoder.elements {
+		elements = append(elements, gst.ElementFactoryMake(name, fmt.Sprintf("%s%s%d", prefix, name, i)))
+	}
+	sink := gst.ElementFactoryMake("fdsink", prefix+"sink")
+	sink.SetProperty("fd", int(o.streamer.pipe.Fd()))
+
+	return append(elements, sink)
+}
+
+// setStreamTitles announces title on every httpd output.
+func setStreamTitles(outputs []*audioOutput, title string) {
+	for _, o := range outputs {
+		if o.streamer != nil {
+			o.streamer.setTitle(title)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MPD idle subsystems
+const (
+	IdleDatabase  = "database"
+	IdleUpdate    = "update"
+	IdlePlaylist  = "playlist"
+	IdlePlayer    = "player"
+	IdleOutput    = "output"
+	IdleOptions   = "options"
+	IdleSticker   = "sticker"
+	IdleMixer     = "mixer"
+	IdlePartition = "partition"
+
+	IdleSubscription = "subscription"
+	IdleMessage      = "message"
+)
+
+// session represents a connected client.
+type session struct {
+	sync.Mutex
+	partition   *partition // the partition the client controls
+	permissions int        // permissions the client was granted
+
+	pending map[string]bool // subsystems changed since the client last heard
+	wake    chan struct{}   // signalled when a subsystem changes
+
+	idling     bool            // is the client waiting in idle?
+	subsystems map[string]bool // subsystems the client is idling on, all if empty
+
+	channels map[string]bool // channels the client is subscribed to
+	messages []message       // messages the client hasn't read yet
+}
+
+// sessions tracks connected clients, to tell them about changes.
+type sessions struct {
+	sync.Mutex
+	sessions map[*session]bool
+}
+
+// newSession allocates a new session, bound to part, with the default
+// permissions.
+func newSession(part *partition) *session {
+	return &session{
+		partition:   part,
+		permissions: daemon.defaultPermissions,
+		pending:     make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+		channels:    make(map[string]bool),
+	}
+}
+
+// changed records that subsystem changed, and wakes the session up.
+func (s *session) changed(subsystem string) {
+	s.Lock()
+	s.pending[subsystem] = true
+	s.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// changes returns the subsystems changed since the session last heard, and
+// forgets them.
+func (s *session) changes() []string {
+	s.Lock()
+	defer s.Unlock()
+	var changed []string
+	for subsystem := range s.pending {
+		changed = append(changed, subsystem)
+		delete(s.pending, subsystem)
+	}
+
+	return changed
+}
+
+// idle starts waiting for changes to subsystems, or to any if none given.
+func (s *session) idle(subsystems []string) {
+	s.idling = true
+	s.subsystems = make(map[string]bool)
+	for _, subsystem := range subsystems {
+		s.subsystems[subsystem] = true
+	}
+}
+
+// flushIdle writes the changes the idling client waits for, and ends the
+// idle. Unless force is set, nothing is written while there are none.
+func (s *session) flushIdle(w io.Writer, force bool) {
+	if !s.idling {
+		return
+	}
+
+	s.Lock()
+	var changed []string
+	for subsystem := range s.pending {
+		if len(s.subsystems) == 0 || s.subsystems[subsystem] {
+			changed = append(changed, subsystem)
+			delete(s.pending, subsystem)
+		}
+	}
+	s.Unlock()
+
+	if len(changed) == 0 && !force {
+		return
+	}
+	for _, subsystem := range changed {
+		fmt.Fprintf(w, "changed: %s\n", subsystem)
+	}
+	fmt.Fprint(w, "OK\n")
+	s.idling = false
+}
+
+// add starts tracking s.
+func (ss *sessions) add(s *session) {
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.sessions == nil {
+		ss.sessions = make(map[*session]bool)
+	}
+	ss.sessions[s] = true
+}
+
+// remove stops tracking s.
+func (ss *sessions) remove(s *session) {
+	ss.Lock()
+	defer ss.Unlock()
+	delete(ss.sessions, s)
+}
+
+// notify tells every session that subsystems changed.
+func (ss *sessions) notify(subsystems ...string) {
+	ss.Lock()
+	defer ss.Unlock()
+	for s := range ss.sessions {
+		for _, subsystem := range subsystems {
+			s.changed(subsystem)
+		}
+	}
+}
+
+// notifyPartition tells sessions bound to part that subsystems changed.
+func (ss *sessions) notifyPartition(part *partition, subsystems ...string) {
+	ss.Lock()
+	defer ss.Unlock()
+	for s := range ss.sessions {
+		if s.partition != part {
+			continue
+		}
+		for _, subsystem := range subsystems {
+			s.changed(subsystem)
+		}
+	}
+}
+
+// bound reports whether any session is bound to part.
+func (ss *sessions) bound(part *partition) bool {
+	ss.Lock()
+	defer ss.Unlock()
+	for s := range ss.sessions {
+		if s.partition == part {
+			return true
+		}
+	}
+
+	return false
+}
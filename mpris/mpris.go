@@ -0,0 +1,473 @@
+// Package mpris exports a player on the D-Bus session bus as an MPRIS2
+// media player, so that desktop media keys and widgets can control it.
+package mpris
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	objectPath          = "/org/mpris/MediaPlayer2"
+	busNamePrefix       = "org.mpris.MediaPlayer2."
+	rootInterface       = "org.mpris.MediaPlayer2"
+	playerInterface     = rootInterface + ".Player"
+	trackListInterface  = rootInterface + ".TrackList"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+	// trackPathPrefix prefixes the object paths of tracks, followed by
+	// their IDs.
+	trackPathPrefix = "/org/gmpd/Track/"
+)
+
+// NoTrack is the object path standing for no track.
+const NoTrack = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+
+// Playback statuses
+const (
+	Playing = "Playing"
+	Paused  = "Paused"
+	Stopped = "Stopped"
+)
+
+// Loop statuses
+const (
+	LoopNone     = "None"
+	LoopTrack    = "Track"
+	LoopPlaylist = "Playlist"
+)
+
+// Metadata describes a track in the track list.
+type Metadata struct {
+	ID          int // identifies the track in the track list
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	TrackNumber int
+	DiscNumber  int
+	Length      time.Duration
+	URL         string
+}
+
+// State is the state of a player, as MPRIS properties tell it.
+type State struct {
+	PlaybackStatus string // one of Playing, Paused or Stopped
+	LoopStatus     string // one of LoopNone, LoopTrack or LoopPlaylist
+	Shuffle        bool
+	Volume         float64   // from 0 to 1
+	Current        *Metadata // the current track, nil when there's none
+	HasNext        bool
+	HasPrevious    bool
+	Tracks         []int // IDs of the tracks in the track list
+}
+
+// Player is a player exported as an MPRIS2 media player, playing a track
+// list in which tracks are identified by ID.
+type Player interface {
+	// State returns the player's state.
+	State() State
+	// Position returns the position in the current track.
+	Position() time.Duration
+
+	Play() error
+	Pause() error
+	PlayPause() error
+	Stop() error
+	Next() error
+	Previous() error
+	// Seek seeks the current track to pos.
+	Seek(pos time.Duration) error
+
+	SetLoopStatus(status string) error
+	SetShuffle(shuffle bool) error
+	SetVolume(volume float64) error
+
+	// Tracks returns the metadata of the tracks with ids, skipping those
+	// not found.
+	Tracks(ids []int) []Metadata
+	// AddTrack adds the track at uri after the track with ID after, or
+	// first when after is -1, and plays it if play is set.
+	AddTrack(uri string, after int, play bool) error
+	RemoveTrack(id int) error
+	// GoTo plays the track with id.
+	GoTo(id int) error
+}
+
+var errUnknownTrack = errors.New("unknown track")
+
+// Server exports a Player on a D-Bus connection.
+type Server struct {
+	conn    *dbus.Conn
+	name    string
+	schemes []string // URI schemes tracks can be added with
+	player  Player
+
+	mu   sync.Mutex
+	last map[string]map[string]interface{} // properties last announced, per interface
+}
+
+// New exports player on conn as the media player name, accepting tracks
+// with URI schemes.
+func New(conn *dbus.Conn, name string, schemes []string, player Player) (*Server, error) {
+	s := &Server{
+		conn:    conn,
+		name:    name,
+		schemes: schemes,
+		player:  player,
+	}
+	if s.schemes == nil {
+		s.schemes = []string{}
+	}
+	s.last = s.properties(player.State())
+
+	exports := []struct {
+		v     interface{}
+		iface string
+	}{
+		{root{s}, rootInterface},
+		{trackList{s}, trackListInterface},
+		{properties{s}, propertiesInterface},
+		{introspect.Introspectable(introspectXML), "org.freedesktop.DBus.Introspectable"},
+	}
+	for _, e := range exports {
+		if err := conn.Export(e.v, objectPath, e.iface); err != nil {
+			return nil, err
+		}
+	}
+	err := conn.ExportMethodTable(mediaPlayer{s}.methods(), objectPath, playerInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.RequestName(busNamePrefix+name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("%s%s is already taken", busNamePrefix, name)
+	}
+
+	return s, nil
+}
+
+// Update announces the properties which changed since last announced,
+// and the track list if it changed. It's to be called as the player
+// changes.
+func (s *Server) Update() error {
+	state := s.player.State()
+	current := s.properties(state)
+
+	s.mu.Lock()
+	last := s.last
+	s.last = current
+	s.mu.Unlock()
+
+	for _, iface := range []string{rootInterface, playerInterface} {
+		changed := make(map[string]dbus.Variant)
+		for name, value := range current[iface] {
+			if !reflect.DeepEqual(value, last[iface][name]) {
+				changed[name] = dbus.MakeVariant(value)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		err := s.conn.Emit(objectPath, propertiesInterface+".PropertiesChanged",
+			iface, changed, []string{})
+		if err != nil {
+			return err
+		}
+	}
+	tracks := current[trackListInterface]["Tracks"]
+	if !reflect.DeepEqual(tracks, last[trackListInterface]["Tracks"]) {
+		return s.conn.Emit(objectPath, trackListInterface+".TrackListReplaced",
+			tracks, currentTrackPath(state))
+	}
+
+	return nil
+}
+
+// properties returns the properties of every interface in state, but for
+// the position, which changes all the time.
+func (s *Server) properties(state State) map[string]map[string]interface{} {
+	tracks := make([]dbus.ObjectPath, len(state.Tracks))
+	for i, id := range state.Tracks {
+		tracks[i] = trackPath(id)
+	}
+	metadata := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(NoTrack)}
+	if state.Current != nil {
+		metadata = metadataMap(*state.Current)
+	}
+	current := state.Current != nil
+
+	return map[string]map[string]interface{}{
+		rootInterface: {
+			"CanQuit":             false,
+			"CanRaise":            false,
+			"HasTrackList":        true,
+			"Identity":            s.name,
+			"SupportedUriSchemes": s.schemes,
+			"SupportedMimeTypes":  []string{},
+		},
+		playerInterface: {
+			"PlaybackStatus": state.PlaybackStatus,
+			"LoopStatus":     state.LoopStatus,
+			"Rate":           1.0,
+			"Shuffle":        state.Shuffle,
+			"Metadata":       metadata,
+			"Volume":         state.Volume,
+			"MinimumRate":    1.0,
+			"MaximumRate":    1.0,
+			"CanGoNext":      state.HasNext,
+			"CanGoPrevious":  state.HasPrevious,
+			"CanPlay":        len(state.Tracks) > 0,
+			"CanPause":       current,
+			"CanSeek":        current && state.Current.Length > 0,
+			"CanControl":     true,
+		},
+		trackListInterface: {
+			"Tracks":        tracks,
+			"CanEditTracks": true,
+		},
+	}
+}
+
+// trackPath returns the object path of the track with id.
+func trackPath(id int) dbus.ObjectPath {
+	return dbus.ObjectPath(trackPathPrefix + strconv.Itoa(id))
+}
+
+// trackID returns the ID of the track at path.
+func trackID(path dbus.ObjectPath) (int, error) {
+	if !strings.HasPrefix(string(path), trackPathPrefix) {
+		return 0, errUnknownTrack
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(path), trackPathPrefix))
+	if err != nil {
+		return 0, errUnknownTrack
+	}
+
+	return id, nil
+}
+
+// currentTrackPath returns the object path of the current track in state.
+func currentTrackPath(state State) dbus.ObjectPath {
+	if state.Current == nil {
+		return NoTrack
+	}
+	return trackPath(state.Current.ID)
+}
+
+// metadataMap returns m as MPRIS metadata.
+func metadataMap(m Metadata) map[string]dbus.Variant {
+	metadata := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(trackPath(m.ID)),
+	}
+	set := func(key string, value interface{}, ok bool) {
+		if ok {
+			metadata[key] = dbus.MakeVariant(value)
+		}
+	}
+	set("mpris:length", int64(m.Length/time.Microsecond), m.Length > 0)
+	set("xesam:title", m.Title, m.Title != "")
+	set("xesam:artist", []string{m.Artist}, m.Artist != "")
+	set("xesam:album", m.Album, m.Album != "")
+	set("xesam:albumArtist", []string{m.AlbumArtist}, m.AlbumArtist != "")
+	set("xesam:genre", []string{m.Genre}, m.Genre != "")
+	set("xesam:trackNumber", int32(m.TrackNumber), m.TrackNumber > 0)
+	set("xesam:discNumber", int32(m.DiscNumber), m.DiscNumber > 0)
+	set("xesam:url", m.URL, m.URL != "")
+
+	return metadata
+}
+
+// dbusError converts err to a D-Bus error.
+func dbusError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return dbus.MakeFailedError(err)
+}
+
+// root implements the org.mpris.MediaPlayer2 interface.
+type root struct {
+	s *Server
+}
+
+// Raise implements org.mpris.MediaPlayer2; there is nothing to raise.
+func (r root) Raise() *dbus.Error {
+	return nil
+}
+
+// Quit implements org.mpris.MediaPlayer2; the player can't be quit.
+func (r root) Quit() *dbus.Error {
+	return nil
+}
+
+// mediaPlayer implements the org.mpris.MediaPlayer2.Player interface.
+type mediaPlayer struct {
+	s *Server
+}
+
+// methods returns the methods of the interface, exported as a table as
+// Seek isn't io.Seeker's.
+func (p mediaPlayer) methods() map[string]interface{} {
+	return map[string]interface{}{
+		"Next":        p.Next,
+		"Previous":    p.Previous,
+		"Pause":       p.Pause,
+		"PlayPause":   p.PlayPause,
+		"Stop":        p.Stop,
+		"Play":        p.Play,
+		"Seek":        p.SeekBy,
+		"SetPosition": p.SetPosition,
+		"OpenUri":     p.OpenUri,
+	}
+}
+
+// Next implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) Next() *dbus.Error {
+	return dbusError(p.s.player.Next())
+}
+
+// Previous implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) Previous() *dbus.Error {
+	return dbusError(p.s.player.Previous())
+}
+
+// Pause implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) Pause() *dbus.Error {
+	return dbusError(p.s.player.Pause())
+}
+
+// PlayPause implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) PlayPause() *dbus.Error {
+	return dbusError(p.s.player.PlayPause())
+}
+
+// Stop implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) Stop() *dbus.Error {
+	return dbusError(p.s.player.Stop())
+}
+
+// Play implements org.mpris.MediaPlayer2.Player.
+func (p mediaPlayer) Play() *dbus.Error {
+	return dbusError(p.s.player.Play())
+}
+
+// SeekBy implements Seek of org.mpris.MediaPlayer2.Player, seeking offset
+// microseconds from the position. Seeking past the end of the track skips
+// to the next one.
+func (p mediaPlayer) SeekBy(offset int64) *dbus.Error {
+	state := p.s.player.State()
+	if state.Current == nil {
+		return nil
+	}
+	pos := p.s.player.Position() + time.Duration(offset)*time.Microsecond
+	if pos < 0 {
+		pos = 0
+	}
+	if state.Current.Length > 0 && pos > state.Current.Length {
+		return dbusError(p.s.player.Next())
+	}
+
+	return p.seek(pos)
+}
+
+// SetPosition implements org.mpris.MediaPlayer2.Player, seeking the track
+// at path to pos microseconds, unless it's no longer the current one.
+func (p mediaPlayer) SetPosition(path dbus.ObjectPath, pos int64) *dbus.Error {
+	state := p.s.player.State()
+	position := time.Duration(pos) * time.Microsecond
+	if state.Current == nil || path != trackPath(state.Current.ID) || position < 0 ||
+		state.Current.Length > 0 && position > state.Current.Length {
+		return nil
+	}
+
+	return p.seek(position)
+}
+
+// seek seeks to pos, and announces it.
+func (p mediaPlayer) seek(pos time.Duration) *dbus.Error {
+	if err := p.s.player.Seek(pos); err != nil {
+		return dbusError(err)
+	}
+	p.s.conn.Emit(objectPath, playerInterface+".Seeked", int64(pos/time.Microsecond))
+
+	return nil
+}
+
+// OpenUri implements org.mpris.MediaPlayer2.Player, adding the track at
+// uri last, and playing it.
+func (p mediaPlayer) OpenUri(uri string) *dbus.Error {
+	tracks := p.s.player.State().Tracks
+	after := -1
+	if len(tracks) > 0 {
+		after = tracks[len(tracks)-1]
+	}
+
+	return dbusError(p.s.player.AddTrack(uri, after, true))
+}
+
+// trackList implements the org.mpris.MediaPlayer2.TrackList interface.
+type trackList struct {
+	s *Server
+}
+
+// GetTracksMetadata implements org.mpris.MediaPlayer2.TrackList.
+func (t trackList) GetTracksMetadata(paths []dbus.ObjectPath) ([]map[string]dbus.Variant, *dbus.Error) {
+	var ids []int
+	for _, path := range paths {
+		if id, err := trackID(path); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	metadata := []map[string]dbus.Variant{}
+	for _, m := range t.s.player.Tracks(ids) {
+		metadata = append(metadata, metadataMap(m))
+	}
+
+	return metadata, nil
+}
+
+// AddTrack implements org.mpris.MediaPlayer2.TrackList.
+func (t trackList) AddTrack(uri string, after dbus.ObjectPath, setAsCurrent bool) *dbus.Error {
+	id := -1
+	if after != NoTrack {
+		var err error
+		if id, err = trackID(after); err != nil {
+			return dbusError(err)
+		}
+	}
+
+	return dbusError(t.s.player.AddTrack(uri, id, setAsCurrent))
+}
+
+// RemoveTrack implements org.mpris.MediaPlayer2.TrackList.
+func (t trackList) RemoveTrack(path dbus.ObjectPath) *dbus.Error {
+	id, err := trackID(path)
+	if err != nil {
+		return dbusError(err)
+	}
+
+	return dbusError(t.s.player.RemoveTrack(id))
+}
+
+// GoTo implements org.mpris.MediaPlayer2.TrackList.
+func (t trackList) GoTo(path dbus.ObjectPath) *dbus.Error {
+	id, err := trackID(path)
+	if err != nil {
+		return dbusError(err)
+	}
+
+	return dbusError(t.s.player.GoTo(id))
+}
@@ -0,0 +1,215 @@
+package mpris
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakePlayer is a Player keeping its state, and the position sought.
+type fakePlayer struct {
+	state  State
+	sought time.Duration
+}
+
+func (f *fakePlayer) State() State            { return f.state }
+func (f *fakePlayer) Position() time.Duration { return 10 * time.Second }
+func (f *fakePlayer) Play() error             { f.state.PlaybackStatus = Playing; return nil }
+func (f *fakePlayer) Pause() error            { f.state.PlaybackStatus = Paused; return nil }
+func (f *fakePlayer) Stop() error             { f.state.PlaybackStatus = Stopped; return nil }
+func (f *fakePlayer) Next() error             { f.state.Current = &Metadata{ID: 2}; return nil }
+func (f *fakePlayer) Previous() error         { return nil }
+func (f *fakePlayer) Seek(pos time.Duration) error {
+	f.sought = pos
+	return nil
+}
+
+func (f *fakePlayer) PlayPause() error {
+	if f.state.PlaybackStatus == Playing {
+		return f.Pause()
+	}
+	return f.Play()
+}
+
+func (f *fakePlayer) SetLoopStatus(status string) error { f.state.LoopStatus = status; return nil }
+func (f *fakePlayer) SetShuffle(shuffle bool) error     { f.state.Shuffle = shuffle; return nil }
+func (f *fakePlayer) SetVolume(volume float64) error    { f.state.Volume = volume; return nil }
+
+func (f *fakePlayer) Tracks(ids []int) []Metadata {
+	var tracks []Metadata
+	for _, id := range ids {
+		tracks = append(tracks, Metadata{ID: id, Title: "Title", Length: time.Minute})
+	}
+	return tracks
+}
+
+func (f *fakePlayer) AddTrack(uri string, after int, play bool) error {
+	f.state.Tracks = append(f.state.Tracks, len(f.state.Tracks)+1)
+	return nil
+}
+
+func (f *fakePlayer) RemoveTrack(id int) error { return nil }
+func (f *fakePlayer) GoTo(id int) error        { return nil }
+
+// privateBus starts a private dbus-daemon, and returns a connection to it
+// for the server, and another for a client.
+func privateBus(t *testing.T) (server, client *dbus.Conn) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func() *dbus.Conn {
+		conn, err := dbus.Connect(strings.TrimSpace(address))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	return connect(), connect()
+}
+
+func newTestServer(t *testing.T) (*Server, *fakePlayer, dbus.BusObject, *dbus.Conn) {
+	serverConn, client := privateBus(t)
+	player := &fakePlayer{state: State{
+		PlaybackStatus: Paused,
+		LoopStatus:     LoopNone,
+		Volume:         0.5,
+		Current:        &Metadata{ID: 1, Title: "Title", Artist: "Artist", Length: time.Minute},
+		Tracks:         []int{1, 2},
+	}}
+	s, err := New(serverConn, "test", nil, player)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, player, client.Object(busNamePrefix+"test", objectPath), client
+}
+
+func TestProperties(t *testing.T) {
+	_, _, obj, _ := newTestServer(t)
+
+	identity, err := obj.GetProperty(rootInterface + ".Identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Value() != "test" {
+		t.Errorf("Identity = %v, want test", identity.Value())
+	}
+
+	var metadata map[string]dbus.Variant
+	err = obj.Call(propertiesInterface+".Get", 0, playerInterface, "Metadata").Store(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["mpris:trackid"].Value() != trackPath(1) ||
+		metadata["xesam:title"].Value() != "Title" ||
+		metadata["mpris:length"].Value() != int64(60000000) {
+		t.Errorf("Metadata = %v", metadata)
+	}
+
+	position, err := obj.GetProperty(playerInterface + ".Position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Value() != int64(10000000) {
+		t.Errorf("Position = %v, want 10000000", position.Value())
+	}
+}
+
+func TestSetProperty(t *testing.T) {
+	_, player, obj, _ := newTestServer(t)
+
+	if err := obj.SetProperty(playerInterface+".Volume", dbus.MakeVariant(0.8)); err != nil {
+		t.Fatal(err)
+	}
+	if player.state.Volume != 0.8 {
+		t.Errorf("Volume = %v, want 0.8", player.state.Volume)
+	}
+	if err := obj.SetProperty(playerInterface+".LoopStatus", dbus.MakeVariant("Sometimes")); err == nil {
+		t.Error("set LoopStatus to Sometimes")
+	}
+	if err := obj.SetProperty(playerInterface+".PlaybackStatus", dbus.MakeVariant(Playing)); err == nil {
+		t.Error("set read-only PlaybackStatus")
+	}
+}
+
+func TestMethodsAndSignals(t *testing.T) {
+	s, player, obj, client := newTestServer(t)
+	err := client.AddMatchSignal(dbus.WithMatchObjectPath(objectPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	client.Signal(signals)
+
+	if err := obj.Call(playerInterface+".PlayPause", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if player.state.PlaybackStatus != Playing {
+		t.Errorf("PlaybackStatus = %s after PlayPause, want %s", player.state.PlaybackStatus, Playing)
+	}
+	if err := s.Update(); err != nil {
+		t.Fatal(err)
+	}
+	signal := <-signals
+	if signal.Name != propertiesInterface+".PropertiesChanged" {
+		t.Fatalf("got signal %s, want PropertiesChanged", signal.Name)
+	}
+	changed := signal.Body[1].(map[string]dbus.Variant)
+	if len(changed) != 1 || changed["PlaybackStatus"].Value() != Playing {
+		t.Errorf("changed %v, want PlaybackStatus only", changed)
+	}
+
+	if err := obj.Call(playerInterface+".Seek", 0, int64(5000000)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if player.sought != 15*time.Second {
+		t.Errorf("sought %s, want 15s", player.sought)
+	}
+	signal = <-signals
+	if signal.Name != playerInterface+".Seeked" || signal.Body[0] != int64(15000000) {
+		t.Errorf("got signal %s %v, want Seeked to 15000000", signal.Name, signal.Body)
+	}
+
+	var metadata []map[string]dbus.Variant
+	err = obj.Call(trackListInterface+".GetTracksMetadata", 0,
+		[]dbus.ObjectPath{trackPath(2), "/elsewhere"}).Store(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata) != 1 || metadata[0]["mpris:trackid"].Value() != trackPath(2) {
+		t.Errorf("GetTracksMetadata = %v, want track 2's", metadata)
+	}
+
+	if err := obj.Call(trackListInterface+".AddTrack", 0, "tone://440", NoTrack, false).Err; err != nil {
+		t.Fatal(err)
+	}
+	s.Update()
+	signal = <-signals
+	if signal.Name != trackListInterface+".TrackListReplaced" || len(signal.Body[0].([]dbus.ObjectPath)) != 3 {
+		t.Errorf("got signal %s %v, want TrackListReplaced with 3 tracks", signal.Name, signal.Body)
+	}
+}
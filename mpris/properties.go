@@ -0,0 +1,122 @@
+package mpris
+
+import (
+	"errors"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+var errInvalidValue = errors.New("invalid value")
+
+// writableProperties maps the writable properties of the Player interface
+// to their setters.
+var writableProperties = map[string]func(p Player, value dbus.Variant) error{
+	"LoopStatus": func(p Player, value dbus.Variant) error {
+		status, ok := value.Value().(string)
+		if !ok || status != LoopNone && status != LoopTrack && status != LoopPlaylist {
+			return errInvalidValue
+		}
+		return p.SetLoopStatus(status)
+	},
+	"Shuffle": func(p Player, value dbus.Variant) error {
+		shuffle, ok := value.Value().(bool)
+		if !ok {
+			return errInvalidValue
+		}
+		return p.SetShuffle(shuffle)
+	},
+	"Volume": func(p Player, value dbus.Variant) error {
+		volume, ok := value.Value().(float64)
+		if !ok {
+			return errInvalidValue
+		}
+		if volume < 0 {
+			volume = 0
+		} else if volume > 1 {
+			volume = 1
+		}
+		return p.SetVolume(volume)
+	},
+	"Rate": func(p Player, value dbus.Variant) error {
+		// Only the normal rate is supported, which is a no-op.
+		if rate, ok := value.Value().(float64); !ok || rate != 1 {
+			return errInvalidValue
+		}
+		return nil
+	},
+}
+
+// properties implements the org.freedesktop.DBus.Properties interface.
+type properties struct {
+	s *Server
+}
+
+// all returns the properties of iface.
+func (p properties) all(iface string) (map[string]interface{}, *dbus.Error) {
+	props, ok := p.s.properties(p.s.player.State())[iface]
+	if !ok {
+		return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface",
+			[]interface{}{"unknown interface " + iface})
+	}
+	if iface == playerInterface {
+		props["Position"] = int64(p.s.player.Position() / time.Microsecond)
+	}
+
+	return props, nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.
+func (p properties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	props, err := p.all(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty",
+			[]interface{}{"unknown property " + name})
+	}
+
+	return dbus.MakeVariant(value), nil
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.
+func (p properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	props, err := p.all(iface)
+	if err != nil {
+		return nil, err
+	}
+	variants := make(map[string]dbus.Variant, len(props))
+	for name, value := range props {
+		variants[name] = dbus.MakeVariant(value)
+	}
+
+	return variants, nil
+}
+
+// Set implements org.freedesktop.DBus.Properties.
+func (p properties) Set(iface, name string, value dbus.Variant) *dbus.Error {
+	props, err := p.all(iface)
+	if err != nil {
+		return err
+	}
+	if _, ok := props[name]; !ok {
+		return dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty",
+			[]interface{}{"unknown property " + name})
+	}
+	set, ok := writableProperties[name]
+	if !ok || iface != playerInterface {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly",
+			[]interface{}{"property " + name + " is read-only"})
+	}
+	switch err := set(p.s.player, value); err {
+	case nil:
+		return nil
+	case errInvalidValue:
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs",
+			[]interface{}{"invalid value for " + name})
+	default:
+		return dbusError(err)
+	}
+}
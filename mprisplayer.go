@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amir/gmpd/mpris"
+	"github.com/amir/gmpd/queue"
+	"github.com/amir/gmpd/util"
+	"github.com/godbus/dbus/v5"
+)
+
+// mprisName names gmpd on the session bus, as org.mpris.MediaPlayer2.gmpd.
+const mprisName = "gmpd"
+
+// mprisPlayer is the player of a session's partition, as MPRIS sees it.
+// It's controlled through MPD commands, as the session's client.
+type mprisPlayer struct {
+	s *session
+}
+
+// command runs an MPD command, returning its response.
+func (p mprisPlayer) command(format string, args ...interface{}) ([]byte, error) {
+	commandString := fmt.Sprintf(format, args...)
+	daemon.Lock()
+	response, ackError := processCommand(p.s, commandString)
+	daemon.Unlock()
+	if ackError > 0 {
+		return nil, fmt.Errorf("%s: %s", commandString, ackMessage(ackError))
+	}
+
+	return response, nil
+}
+
+// run runs MPD commands in turn, stopping at the first failing.
+func (p mprisPlayer) run(commands ...string) error {
+	for _, command := range commands {
+		if _, err := p.command("%s", command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// metadata returns the MPRIS metadata of item.
+func (p mprisPlayer) metadata(item *queue.Item) mpris.Metadata {
+	m := mpris.Metadata{ID: item.ID}
+	t, err := findTrack(item.Track)
+	if err != nil {
+		return m
+	}
+	millis, _ := strconv.Atoi(t.DurationMillis)
+	m.Title = t.Title
+	m.Artist = t.Artist
+	m.Album = t.Album
+	m.AlbumArtist = t.AlbumArtist
+	m.Genre = t.Genre
+	m.TrackNumber = t.TrackNumber
+	m.DiscNumber = t.DiscNumber
+	m.Length = time.Duration(millis) * time.Millisecond
+	if strings.HasPrefix(item.Track, toneScheme) {
+		m.URL = item.Track
+	}
+
+	return m
+}
+
+// State implements mpris.Player.
+func (p mprisPlayer) State() mpris.State {
+	daemon.Lock()
+	defer daemon.Unlock()
+	playlist, player := p.s.partition.playlist, p.s.partition.player
+
+	state := mpris.State{
+		PlaybackStatus: mpris.Stopped,
+		LoopStatus:     mpris.LoopNone,
+		Shuffle:        playlist.Random,
+		Volume:         float64(player.volume) / 100,
+	}
+	switch player.state() {
+	case "play":
+		state.PlaybackStatus = mpris.Playing
+	case "pause":
+		state.PlaybackStatus = mpris.Paused
+	}
+	switch {
+	case playlist.Repeat && playlist.Single:
+		state.LoopStatus = mpris.LoopTrack
+	case playlist.Repeat:
+		state.LoopStatus = mpris.LoopPlaylist
+	}
+	for _, item := range playlist.Items {
+		state.Tracks = append(state.Tracks, item.ID)
+	}
+	if state.PlaybackStatus != mpris.Stopped {
+		if item, err := playlist.ItemAtPosition(playlist.Position); err == nil {
+			current := p.metadata(item)
+			state.Current = &current
+		}
+		state.HasNext = playlist.NextPosition() != -1
+		state.HasPrevious = true
+	}
+
+	return state
+}
+
+// Position implements mpris.Player.
+func (p mprisPlayer) Position() time.Duration {
+	daemon.Lock()
+	defer daemon.Unlock()
+	if ok, pos := p.s.partition.player.position(); ok {
+		return time.Duration(pos)
+	}
+
+	return 0
+}
+
+// Play implements mpris.Player.
+func (p mprisPlayer) Play() error {
+	return p.run("play")
+}
+
+// Pause implements mpris.Player.
+func (p mprisPlayer) Pause() error {
+	return p.run("pause 1")
+}
+
+// PlayPause implements mpris.Player.
+func (p mprisPlayer) PlayPause() error {
+	if p.State().PlaybackStatus == mpris.Playing {
+		return p.Pause()
+	}
+	return p.Play()
+}
+
+// Stop implements mpris.Player.
+func (p mprisPlayer) Stop() error {
+	return p.run("stop")
+}
+
+// Next implements mpris.Player.
+func (p mprisPlayer) Next() error {
+	return p.run("next")
+}
+
+// Previous implements mpris.Player.
+func (p mprisPlayer) Previous() error {
+	return p.run("previous")
+}
+
+// Seek implements mpris.Player.
+func (p mprisPlayer) Seek(pos time.Duration) error {
+	return p.run(fmt.Sprintf("seekcur %.3f", pos.Seconds()))
+}
+
+// SetLoopStatus implements mpris.Player.
+func (p mprisPlayer) SetLoopStatus(status string) error {
+	switch status {
+	case mpris.LoopTrack:
+		return p.run("repeat 1", "single 1")
+	case mpris.LoopPlaylist:
+		return p.run("repeat 1", "single 0")
+	default:
+		return p.run("repeat 0", "single 0")
+	}
+}
+
+// SetShuffle implements mpris.Player.
+func (p mprisPlayer) SetShuffle(shuffle bool) error {
+	return p.run(fmt.Sprintf("random %d", boolFlag(shuffle)))
+}
+
+// SetVolume implements mpris.Player.
+func (p mprisPlayer) SetVolume(volume float64) error {
+	return p.run(fmt.Sprintf("setvol %d", int(math.Floor(volume*100+0.5))))
+}
+
+// Tracks implements mpris.Player.
+func (p mprisPlayer) Tracks(ids []int) []mpris.Metadata {
+	daemon.Lock()
+	defer daemon.Unlock()
+	playlist := p.s.partition.playlist
+
+	var tracks []mpris.Metadata
+	for _, id := range ids {
+		if item, err := playlist.ItemAtPosition(playlist.IDPosition(id)); err == nil {
+			tracks = append(tracks, p.metadata(item))
+		}
+	}
+
+	return tracks
+}
+
+// AddTrack implements mpris.Player. Without a track to add it after, or
+// when that track is gone, the track is added first.
+func (p mprisPlayer) AddTrack(uri string, after int, play bool) error {
+	daemon.Lock()
+	pos := p.s.partition.playlist.IDPosition(after) + 1
+	daemon.Unlock()
+	response, err := p.command("addid %s %d", util.Quote(uri), pos)
+	if err != nil {
+		return err
+	}
+	var id int
+	if _, err := fmt.Sscanf(string(response), "Id: %d", &id); err != nil {
+		return err
+	}
+	if play {
+		return p.GoTo(id)
+	}
+
+	return nil
+}
+
+// RemoveTrack implements mpris.Player.
+func (p mprisPlayer) RemoveTrack(id int) error {
+	return p.run(fmt.Sprintf("deleteid %d", id))
+}
+
+// GoTo implements mpris.Player.
+func (p mprisPlayer) GoTo(id int) error {
+	return p.run(fmt.Sprintf("playid %d", id))
+}
+
+// mprisService exports the default partition's player on the session bus,
+// and announces its changes.
+func mprisService() {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		log.Fatal(err)
+	}
+	daemon.Lock()
+	s := newSession(daemon.partitions[0])
+	daemon.Unlock()
+	daemon.sessions.add(s)
+
+	server, err := mpris.New(conn, mprisName, []string{strings.TrimSuffix(toneScheme, "://")},
+		mprisPlayer{s})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for range s.wake {
+		s.changes()
+		if err := server.Update(); err != nil {
+			log.Printf("MPRIS: %s", err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/amir/gst"
+)
+
+// outputPlugins maps output plugins to their GStreamer sink elements.
+var outputPlugins = map[string]string{
+	"autoaudiosink": "autoaudiosink",
+	"pulse":         "pulsesink",
+	"alsa":          "alsasink",
+	"file":          "filesink",
+	"null":          "fakesink",
+	"httpd":         "fdsink",
+}
+
+// outputProperties maps output attributes to sink element properties, per
+// plugin. Other attributes are kept, but don't affect the sink.
+var outputProperties = map[string]map[string]string{
+	"pulse": {"device": "device", "server": "server"},
+	"alsa":  {"device": "device"},
+	"file":  {"path": "location"},
+}
+
+// runtimeAttributes are the output attributes clients may set with
+// outputset. The others, such as a file output's path and fifo, an httpd
+// output's port and bind_to_address, or an ALSA device, which can name a
+// file too, are only set in the configuration.
+var runtimeAttributes = map[string]bool{
+	"encoder":  true,
+	"rate":     true,
+	"channels": true,
+	"format":   true,
+}
+
+var errNoSuchOutput = errors.New("no such audio output")
+
+// audioOutput represents an audio output the player can play to.
+type audioOutput struct {
+	id         int
+	name       string
+	plugin     string            // one of outputPlugins
+	enabled    bool              // is it being played to?
+	attributes map[string]string // plugin settings, e.g. device or path
+	streamer   *httpStreamer     // serves an httpd output's listeners
+}
+
+// outputFlags collects audio outputs given on the command line, as
+// plugin[,name=NAME][,enabled=0][,ATTRIBUTE=VALUE...]
+type outputFlags []string
+
+// String implements flag.Value.
+func (o *outputFlags) String() string {
+	return strings.Join(*o, " ")
+}
+
+// Set implements flag.Value.
+func (o *outputFlags) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// parseOutput parses an audio output given on the command line.
+func parseOutput(id int, value string) (*audioOutput, error) {
+	fields := strings.Split(value, ",")
+	o := &audioOutput{
+		id:         id,
+		name:       fields[0],
+		plugin:     fields[0],
+		enabled:    true,
+		attributes: make(map[string]string),
+	}
+	if _, ok := outputPlugins[o.plugin]; !ok {
+		return nil, fmt.Errorf("unknown output plugin %q", o.plugin)
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed output attribute %q", field)
+		}
+		switch kv[0] {
+		case "name":
+			o.name = kv[1]
+		case "enabled":
+			o.enabled = kv[1] != "0"
+		default:
+			o.attributes[kv[0]] = kv[1]
+		}
+	}
+
+	return o, nil
+}
+
+// newOutputs allocates audio outputs given on the command line, defaulting
+// to a single autoaudiosink.
+func newOutputs(values []string) ([]*audioOutput, error) {
+	if len(values) == 0 {
+		values = []string{"autoaudiosink,name=Default output"}
+	}
+	outputs := make([]*audioOutput, len(values))
+	for i, value := range values {
+		o, err := parseOutput(i, value)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = o
+	}
+
+	return outputs, nil
+}
+
+// findOutput returns the audio output with id.
+func findOutput(outputs []*audioOutput, id string) (*audioOutput, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, errNoSuchOutput
+	}
+	for _, o := range outputs {
+		if o.id == n {
+			return o, nil
+		}
+	}
+
+	return nil, errNoSuchOutput
+}
+
+// String returns MPD-response-formatted representation of an output.
+func (o *audioOutput) String() string {
+	enabled := 0
+	if o.enabled {
+		enabled = 1
+	}
+	s := fmt.Sprintf("outputid: %d\noutputname: %s\nplugin: %s\noutputenabled: %d\n",
+		o.id, o.name, o.plugin, enabled)
+
+	keys := make([]string, 0, len(o.attributes))
+	for k := range o.attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s += fmt.Sprintf("attribute: %s=%s\n", k, o.attributes[k])
+	}
+
+	return s
+}
+
+// writeOutputs writes MPD-response-formatted outputs.
+func writeOutputs(w io.Writer, outputs []*audioOutput) {
+	for _, o := range outputs {
+		fmt.Fprintf(w, "%s", o)
+	}
+}
+
+// sink makes the output's chain of elements, ending in its sink.
+func (o *audioOutput) sink() []*gst.Element {
+	prefix := fmt.Sprintf("output%d-", o.id)
+	if o.plugin == "httpd" {
+		return o.httpdSink(prefix)
+	}
+	elements := []*gst.Element{
+		gst.ElementFactoryMake("queue", prefix+"queue"),
+		gst.ElementFactoryMake("audioconvert", prefix+"convert"),
+		gst.ElementFactoryMake("audioresample", prefix+"resample"),
+	}
+	if o.plugin == "file" {
+		elements = append(elements, o.fileEncoder(prefix)...)
+	}
+
+	sink := gst.ElementFactoryMake(outputPlugins[o.plugin], prefix+"sink")
+	for attribute, property := range outputProperties[o.plugin] {
+		if value, ok := o.attributes[attribute]; ok {
+			sink.SetProperty(property, value)
+		}
+	}
+	if o.plugin == "null" {
+		// Keep to real time, as an audio device would.
+		sink.SetProperty("sync", true)
+	}
+
+	return append(elements, sink)
+}
+
+// fileEncoder prepares a file output's path, making a FIFO there if its
+// fifo attribute is set, and returns the elements encoding what's written
+// to it: 16 bit PCM, in a WAV container unless its format attribute is pcm.
+func (o *audioOutput) fileEncoder(prefix string) []*gst.Element {
+	path := o.attributes["path"]
+	if o.attributes["fifo"] == "1" && path != "" {
+		if fi, err := os.Stat(path); os.IsNotExist(err) {
+			if err := syscall.Mkfifo(path, 0600); err != nil {
+				log.Printf("Output %q: %s", o.name, err)
+			}
+		} else if err == nil && fi.Mode()&os.ModeNamedPipe == 0 {
+			log.Printf("Output %q: %s exists and is not a FIFO", o.name, path)
+		}
+	}
+
+	caps := "audio/x-raw-int,width=16,depth=16,signed=true,endianness=1234"
+	if rate := o.attributes["rate"]; rate != "" {
+		caps += ",rate=" + rate
+	}
+	if channels := o.attributes["channels"]; channels != "" {
+		caps += ",channels=" + channels
+	}
+	filter := gst.ElementFactoryMake("capsfilter", prefix+"caps")
+	filter.SetProperty("caps", gst.CapsFromString(caps))
+	if o.attributes["format"] == "pcm" {
+		return []*gst.Element{filter}
+	}
+
+	return []*gst.Element{filter, gst.ElementFactoryMake("wavenc", prefix+"wavenc")}
+}
+
+// newOutputBin makes a bin teeing its sink pad into every enabled output,
+// or into a fakesink when none is.
+func newOutputBin(name string, outputs []*audioOutput) *gst.Bin {
+	bin := gst.NewBin(name)
+	convert := gst.ElementFactoryMake("audioconvert", name+"-convert")
+	tee := gst.ElementFactoryMake("tee", name+"-tee")
+	bin.Add(convert, tee)
+	convert.Link(tee)
+
+	enabled := 0
+	for _, o := range outputs {
+		if !o.enabled {
+			continue
+		}
+		chain := o.sink()
+		bin.Add(chain...)
+		tee.Link(chain[0])
+		chain[0].Link(chain[1:]...)
+		enabled++
+	}
+	if enabled == 0 {
+		sink := gst.ElementFactoryMake("fakesink", name+"-fakesink")
+		// Keep to real time, lest tracks race by unheard.
+		sink.SetProperty("sync", true)
+		bin.Add(sink)
+		tee.Link(sink)
+	}
+
+	bin.AddPad(gst.NewGhostPad("sink", convert.GetStaticPad("sink")).AsPad())
+
+	return bin
+}
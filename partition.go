@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// defaultPartition names the partition sessions start in, which can't be
+// deleted.
+const defaultPartition = "default"
+
+var (
+	errNoSuchPartition   = errors.New("no such partition")
+	errPartitionExists   = errors.New("partition already exists")
+	errPartitionName     = errors.New("invalid partition name")
+	errPartitionNotEmpty = errors.New("partition has clients or outputs")
+)
+
+// partition represents an independent playlist and player, with its own
+// options and audio outputs. Partitions share the content provider.
+type partition struct {
+	name     string
+	playlist *Playlist
+	player   *Player
+	options  *options
+	radio    *radio
+	outputs  []*audioOutput // audio outputs the partition plays to
+}
+
+// newPartition allocates a new partition playing to outputs.
+func newPartition(name string, outputs []*audioOutput) *partition {
+	p := &partition{
+		name:    name,
+		outputs: outputs,
+		options: &options{
+			replayGainMode: ReplayGainOff,
+			mixRampDelay:   math.NaN(),
+		},
+	}
+	p.playlist = &Playlist{partition: p}
+	p.radio = &radio{partition: p}
+	p.player = NewPlayer(p)
+
+	return p
+}
+
+// notify tells sessions bound to p that subsystems changed.
+func (p *partition) notify(subsystems ...string) {
+	daemon.sessions.notifyPartition(p, subsystems...)
+}
+
+// setOutputs has p play to its outputs, once they changed.
+func (p *partition) setOutputs() {
+	p.player.setOutputs(p.outputs)
+	p.notify(IdleOutput)
+}
+
+// validPartitionName reports whether name is fit for a partition: made of
+// letters, digits, dashes, underscores and dots.
+func validPartitionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// partitions tracks the daemon's partitions, in order of creation.
+type partitions []*partition
+
+// find returns the partition named name.
+func (ps partitions) find(name string) (*partition, error) {
+	for _, p := range ps {
+		if p.name == name {
+			return p, nil
+		}
+	}
+
+	return nil, errNoSuchPartition
+}
+
+// add creates a partition named name, without outputs.
+func (ps *partitions) add(name string) (*partition, error) {
+	if !validPartitionName(name) {
+		return nil, errPartitionName
+	}
+	if _, err := ps.find(name); err == nil {
+		return nil, errPartitionExists
+	}
+	p := newPartition(name, nil)
+	*ps = append(*ps, p)
+
+	return p, nil
+}
+
+// remove deletes the partition named name, which must not be the default
+// one, nor have sessions bound to it or outputs.
+func (ps *partitions) remove(name string) error {
+	for i, p := range *ps {
+		if p.name != name {
+			continue
+		}
+		if name == defaultPartition || len(p.outputs) > 0 || daemon.sessions.bound(p) {
+			return errPartitionNotEmpty
+		}
+		p.player.close()
+		*ps = append((*ps)[:i], (*ps)[i+1:]...)
+		return nil
+	}
+
+	return errNoSuchPartition
+}
+
+// playtime returns the time spent playing, summed across partitions.
+func (ps partitions) playtime() time.Duration {
+	var playtime time.Duration
+	for _, p := range ps {
+		playtime += p.player.playtime
+	}
+
+	return playtime
+}
+
+// moveOutput moves the output named name from whichever partition it's in
+// to p.
+func (ps partitions) moveOutput(name string, to *partition) error {
+	for _, from := range ps {
+		for i, o := range from.outputs {
+			if o.name != name {
+				continue
+			}
+			if from == to {
+				return nil
+			}
+			from.outputs = append(from.outputs[:i], from.outputs[i+1:]...)
+			to.outputs = append(to.outputs, o)
+			from.setOutputs()
+			to.setOutputs()
+			return nil
+		}
+	}
+
+	return errNoSuchOutput
+}
+
+// writePartitions writes MPD-response-formatted partition names.
+func writePartitions(w io.Writer, ps partitions) {
+	for _, p := range ps {
+		fmt.Fprintf(w, "partition: %s\n", p.name)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MPD permissions, granted to clients by default or by password.
+const (
+	permissionRead = 1 << iota
+	permissionAdd
+	permissionControl
+	permissionAdmin
+
+	permissionAll = permissionRead | permissionAdd | permissionControl | permissionAdmin
+)
+
+var permissionNames = map[string]int{
+	"read":    permissionRead,
+	"add":     permissionAdd,
+	"control": permissionControl,
+	"admin":   permissionAdmin,
+}
+
+var errBadPassword = errors.New("incorrect password")
+
+// unprivilegedCommands need no permission, for clients to connect,
+// authenticate and find out what they may do.
+var unprivilegedCommands = map[string]bool{
+	"password": true, "ping": true, "close": true, "commands": true,
+	"notcommands": true, "tagtypes": true, "idle": true, "noidle": true,
+}
+
+// commandPermissions maps commands to the permission they need, when it's
+// more than the read permission.
+var commandPermissions = map[string]int{
+	"add": permissionAdd, "addid": permissionAdd,
+
+	"play": permissionControl, "playid": permissionControl,
+	"next": permissionControl, "previous": permissionControl,
+	"stop": permissionControl, "pause": permissionControl,
+	"seekcur": permissionControl, "deleteid": permissionControl,
+	"prio": permissionControl, "prioid": permissionControl,
+	"random": permissionControl, "repeat": permissionControl,
+	"single": permissionControl, "consume": permissionControl,
+	"radio": permissionControl, "setvol": permissionControl,
+	"clearerror": permissionControl, "crossfade": permissionControl,
+	"mixrampdb": permissionControl, "mixrampdelay": permissionControl,
+	"replay_gain_mode": permissionControl, "sendmessage": permissionControl,
+	"update": permissionControl, "rescan": permissionControl,
+
+	"sticker": permissionAdmin, "enableoutput": permissionAdmin,
+	"disableoutput": permissionAdmin, "toggleoutput": permissionAdmin,
+	"outputset": permissionAdmin, "newpartition": permissionAdmin,
+	"delpartition": permissionAdmin, "moveoutput": permissionAdmin,
+}
+
+// commandPermission returns the permission command needs. Commands not
+// listed, unknown ones included, need the read permission.
+func commandPermission(command string) int {
+	if unprivilegedCommands[command] {
+		return 0
+	}
+	if permission, ok := commandPermissions[command]; ok {
+		return permission
+	}
+
+	return permissionRead
+}
+
+// parsePermissions parses a comma-separated list of permissions.
+func parsePermissions(s string) (int, error) {
+	permissions := 0
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		permission, ok := permissionNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission %q", name)
+		}
+		permissions |= permission
+	}
+
+	return permissions, nil
+}
+
+// passwordFlags collects passwords given on the command line, as
+// PASSWORD@PERMISSION[,PERMISSION...]
+type passwordFlags []string
+
+// String implements flag.Value.
+func (p *passwordFlags) String() string {
+	return strings.Join(*p, " ")
+}
+
+// Set implements flag.Value.
+func (p *passwordFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// parsePasswords maps passwords given on the command line to the
+// permissions they grant.
+func parsePasswords(values []string) (map[string]int, error) {
+	passwords := make(map[string]int)
+	for _, value := range values {
+		i := strings.LastIndex(value, "@")
+		if i == -1 {
+			return nil, fmt.Errorf("password %q grants no permissions", value)
+		}
+		permissions, err := parsePermissions(value[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		passwords[value[:i]] = permissions
+	}
+
+	return passwords, nil
+}
+
+// allowed reports whether s may run command.
+func (s *session) allowed(command string) bool {
+	need := commandPermission(command)
+	return s.permissions&need == need
+}
+
+// authenticate grants s the permissions of password.
+func (s *session) authenticate(password string) error {
+	permissions, ok := daemon.passwords[password]
+	if !ok {
+		return errBadPassword
+	}
+	s.permissions = permissions
+
+	return nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/amir/gst"
+)
+
+const (
+	// fadeStep is the interval between volume changes during a crossfade.
+	fadeStep = 50 * time.Millisecond
+	// watchInterval is how often the player checks whether to crossfade.
+	watchInterval = 250 * time.Millisecond
+	// prerollTimeout bounds how long a deck may take to preroll, before
+	// it's seeked back to where it was.
+	prerollTimeout = 10 * time.Second
+)
+
+// deck represents one of the player's two GStreamer playbins, each decoding
+// a track into the player's mixer.
+type deck struct {
+	player   *Player
+	pipe     *gst.Element
+	bus      *gst.Bus
+	sink     *gst.Element  // feeds the mixer
+	rgvolume *gst.Element  // applies ReplayGain, nil when it's off
+	track    string        // ID of the track being played
+	level    float64       // volume of the deck, relative to the player's
+	bitrate  uint32        // bitrate of the track, in bits per second
+	duration time.Duration // duration of the track, 0 if unknown
+}
+
+// Player represents two decks mixed into the audio outputs of a partition,
+// so that a track can fade into the next.
+type Player struct {
+	partition *partition
+	name      string        // names the channels between decks and mixer
+	closed    bool          // is the partition deleted?
+	mixer     *gst.Pipeline // mixes decks into the audio outputs
+	decks     [2]*deck
+	active    int    // index of the deck playing the current track
+	fadeGen   int    // incremented when a crossfade starts, or is cut short
+	listen    listen // the current track, as it's listened to
+
+	playtime  time.Duration // time spent playing
+	volume    int           // 0 to 100
+	lastError string        // last playback error, until cleared
+	failures  int           // failures to play the current track
+	skipped   int           // tracks skipped in a row as they failed
+}
+
+// deckChannel names the channel the ith deck of a player feeds the mixer
+// through.
+func deckChannel(name string, i int) string {
+	return fmt.Sprintf("%s-deck%d", name, i)
+}
+
+// onMessage is GStreamer's playbin bus message callback.
+func (d *deck) onMessage(bus *gst.Bus, msg *gst.Message) {
+	daemon.Lock()
+	defer daemon.Unlock()
+
+	p := d.player
+	switch msg.GetType() {
+	case gst.MESSAGE_EOS:
+		d.stop()
+		if d != p.current() {
+			// The track faded out, the next one plays on the other deck.
+			return
+		}
+		p.endListen(true)
+		p.partition.playlist.playNext()
+		p.partition.notify(IdlePlayer)
+	case gst.MESSAGE_ERROR:
+		err, debug := msg.ParseError()
+		d.onError(classifyPlaybackError(err.Error(), debug))
+		p.partition.notify(IdlePlayer)
+	case gst.MESSAGE_TAG:
+		tags := msg.ParseTag()
+		if rg, ok := replayGainFromTags(tags); ok {
+			daemon.cp.SetReplayGain(d.track, rg)
+		}
+		if bitrate, ok := tags.GetUint("bitrate"); ok {
+			d.bitrate = bitrate
+		} else if bitrate, ok := tags.GetUint("nominal-bitrate"); ok && d.bitrate == 0 {
+			d.bitrate = bitrate
+		}
+	}
+}
+
+// onSyncMessage is GStreamer's playbin bus sync element callback.
+func (d *deck) onSyncMessage(bus *gst.Bus, msg *gst.Message) {
+}
+
+// load sets deck's URI property, and the fallback ReplayGain of track. The
+// track's duration is looked up once, rather than on every watch.
+func (d *deck) load(track, url string) {
+	d.track = track
+	d.bitrate = 0
+	d.duration, _ = trackDuration(track)
+	d.applyReplayGain()
+	d.pipe.SetProperty("uri", url)
+}
+
+// applyReplayGain sets deck's ReplayGain album mode, and the fallback gain
+// of its track, from the partition's mode and play order.
+func (d *deck) applyReplayGain() {
+	if d.rgvolume == nil {
+		return
+	}
+	part := d.player.partition
+	album := replayGainAlbumMode(part.options.replayGainMode, part.playlist.Random)
+	d.rgvolume.SetProperty("album-mode", album)
+	if d.track == "" {
+		return
+	}
+	rg, _ := daemon.cp.ReplayGain(d.track)
+	d.rgvolume.SetProperty("fallback-gain", replayGainFallback(rg, album,
+		*replayGainPreamp, *replayGainLimit))
+}
+
+// setVolume sets deck's volume, 1.0 being the player's volume.
+func (d *deck) setVolume(level float64) {
+	d.level = level
+	d.pipe.SetProperty("volume", level*float64(d.player.volume)/100)
+}
+
+// stop stops deck.
+func (d *deck) stop() {
+	d.pipe.SetState(gst.STATE_NULL)
+}
+
+// setReplayGainMode rebuilds deck's audio filter to apply ReplayGain in
+// mode, resuming playback where it was.
+func (d *deck) setReplayGainMode(mode string) {
+	state := stateOf(d.pipe)
+	ok, pos := d.pipe.GetPosition()
+
+	d.pipe.SetState(gst.STATE_NULL)
+	filter, rgvolume := newReplayGainFilter(mode, d.player.partition.playlist.Random)
+	d.pipe.SetProperty("audio-filter", filter)
+	d.rgvolume = rgvolume
+	d.applyReplayGain()
+	if state != gst.STATE_PLAYING && state != gst.STATE_PAUSED {
+		return
+	}
+	if !ok {
+		d.pipe.SetState(state)
+		return
+	}
+	d.pipe.SetState(gst.STATE_PAUSED)
+	go d.seekWhenReady(d.player.fadeGen, pos, state)
+}
+
+// seekWhenReady waits for deck to preroll, without holding the daemon's
+// lock, then seeks it to pos and sets it to state, unless the player
+// moved on meanwhile, as told by gen.
+func (d *deck) seekWhenReady(gen int, pos int64, state gst.State) {
+	d.pipe.GetState(int64(prerollTimeout))
+
+	daemon.Lock()
+	defer daemon.Unlock()
+	if gen != d.player.fadeGen {
+		return
+	}
+	d.pipe.SeekSimple(gst.FORMAT_TIME, gst.SEEK_FLAG_FLUSH|gst.SEEK_FLAG_KEY_UNIT, pos)
+	d.pipe.SetState(state)
+}
+
+// stateOf returns the state e is in, or the one it's changing to, without
+// waiting for a change to complete, which may take as long as a stream
+// takes to buffer.
+func stateOf(e *gst.Element) gst.State {
+	state, pending, ret := e.GetState(0)
+	if ret == gst.STATE_CHANGE_ASYNC && pending != gst.STATE_VOID_PENDING {
+		return pending
+	}
+
+	return state
+}
+
+// applyReplayGain reapplies ReplayGain on player's decks, once the play
+// order changed, as auto mode picks album gain unless it's random.
+func (p *Player) applyReplayGain() {
+	for _, d := range p.decks {
+		d.applyReplayGain()
+	}
+}
+
+// current returns the deck playing the current track.
+func (p *Player) current() *deck {
+	return p.decks[p.active]
+}
+
+// other returns the deck not playing the current track.
+func (p *Player) other() *deck {
+	return p.decks[1-p.active]
+}
+
+// play plays track from url, cutting any crossfade short.
+func (p *Player) play(track, url string) {
+	p.endListen(false)
+	p.fadeGen++
+	p.failures = 0
+	p.other().stop()
+
+	d := p.current()
+	d.stop()
+	d.load(track, url)
+	d.setVolume(1)
+	d.pipe.SetState(gst.STATE_PLAYING)
+	p.mixer.SetState(gst.STATE_PLAYING)
+
+	p.trackChanged(track)
+}
+
+// crossfade starts playing track from url on the other deck, and fades it
+// in while fading the current track out over duration.
+func (p *Player) crossfade(track, url string, duration time.Duration) {
+	p.endListen(true)
+	p.fadeGen++
+	p.failures = 0
+	from := p.current()
+	to := p.other()
+	p.active = 1 - p.active
+
+	to.stop()
+	to.load(track, url)
+	to.setVolume(0)
+	to.pipe.SetState(gst.STATE_PLAYING)
+	go p.fade(p.fadeGen, from, to, duration)
+
+	p.trackChanged(track)
+}
+
+// fade ramps from's volume down and to's up over duration, then stops from,
+// unless another crossfade or a change of track cuts it short.
+func (p *Player) fade(gen int, from, to *deck, duration time.Duration) {
+	steps := int(duration / fadeStep)
+	for i := 1; i <= steps; i++ {
+		time.Sleep(fadeStep)
+
+		daemon.Lock()
+		if gen != p.fadeGen {
+			daemon.Unlock()
+			return
+		}
+		if p.state() == "play" {
+			volume := float64(i) / float64(steps)
+			from.setVolume(1 - volume)
+			to.setVolume(volume)
+		} else {
+			i--
+		}
+		daemon.Unlock()
+	}
+
+	daemon.Lock()
+	if gen == p.fadeGen {
+		from.stop()
+		to.setVolume(1)
+	}
+	daemon.Unlock()
+}
+
+// seek seeks the current track to pos, cutting any crossfade short.
+func (p *Player) seek(pos time.Duration) {
+	p.fadeGen++
+	p.other().stop()
+	d := p.current()
+	d.setVolume(1)
+	d.pipe.SeekSimple(gst.FORMAT_TIME, gst.SEEK_FLAG_FLUSH|gst.SEEK_FLAG_KEY_UNIT, int64(pos))
+}
+
+// endListen ends listening to the current track, recording it in the
+// history. A complete listen counts the whole track, as it played to its
+// end or faded out.
+func (p *Player) endListen(complete bool) {
+	l := p.listen
+	if l.track == "" {
+		return
+	}
+	p.listen = listen{}
+	if ok, pos := p.position(); ok && time.Duration(pos) > l.listened {
+		l.listened = time.Duration(pos)
+	}
+	if duration, ok := trackDuration(l.track); ok && complete {
+		l.listened = duration
+	}
+	recordListen(l)
+}
+
+// trackChanged starts listening to, and announces, the track now playing.
+func (p *Player) trackChanged(track string) {
+	p.listen = listen{track: track, start: time.Now()}
+	scrobbleNowPlaying(track)
+	if t, err := findTrack(track); err == nil {
+		setStreamTitles(p.partition.outputs, t.Artist+" - "+t.Title)
+	}
+}
+
+// pause pauses player if its playing.
+func (p *Player) pause() {
+	if p.state() != "play" {
+		return
+	}
+	for _, d := range p.decks {
+		if stateOf(d.pipe) == gst.STATE_PLAYING {
+			d.pipe.SetState(gst.STATE_PAUSED)
+		}
+	}
+	p.mixer.SetState(gst.STATE_PAUSED)
+}
+
+// resume resumes player if it's paused.
+func (p *Player) resume() {
+	if p.state() != "pause" {
+		return
+	}
+	for _, d := range p.decks {
+		if stateOf(d.pipe) == gst.STATE_PAUSED {
+			d.pipe.SetState(gst.STATE_PLAYING)
+		}
+	}
+	p.mixer.SetState(gst.STATE_PLAYING)
+}
+
+// stop stops player.
+func (p *Player) stop() {
+	p.endListen(false)
+	p.fadeGen++
+	for _, d := range p.decks {
+		d.stop()
+	}
+	p.mixer.SetState(gst.STATE_NULL)
+}
+
+// setVolume sets player's volume, from 0 to 100.
+func (p *Player) setVolume(volume int) {
+	p.volume = volume
+	for _, d := range p.decks {
+		d.setVolume(d.level)
+	}
+}
+
+// audioFormat returns the format of the audio decoded by the current deck,
+// as rate:bits:channels, if it's known.
+func (p *Player) audioFormat() (string, bool) {
+	pad := p.current().sink.GetStaticPad("sink")
+	if pad == nil {
+		return "", false
+	}
+	caps := pad.GetNegotiatedCaps()
+	if caps == nil {
+		return "", false
+	}
+	s := caps.GetStructure(0)
+	rate, ok := s.GetInt("rate")
+	if !ok {
+		return "", false
+	}
+	bits, _ := s.GetInt("width")
+	channels, _ := s.GetInt("channels")
+
+	return fmt.Sprintf("%d:%d:%d", rate, bits, channels), true
+}
+
+// position returns the position of the current track, in nanoseconds.
+func (p *Player) position() (bool, int64) {
+	return p.current().pipe.GetPosition()
+}
+
+// state reports player's state.
+func (p *Player) state() string {
+	switch stateOf(p.current().pipe) {
+	case gst.STATE_PLAYING:
+		return "play"
+	case gst.STATE_PAUSED:
+		return "pause"
+	default:
+		return "stop"
+	}
+}
+
+// close stops player for good, as its partition is deleted.
+func (p *Player) close() {
+	p.stop()
+	p.closed = true
+}
+
+// setOutputs rebuilds player's mixer to play to outputs.
+func (p *Player) setOutputs(outputs []*audioOutput) {
+	state := stateOf(p.mixer.AsElement())
+	p.mixer.SetState(gst.STATE_NULL)
+	p.mixer = newMixer(p.name, outputs)
+	p.mixer.SetState(state)
+}
+
+// setReplayGainMode rebuilds player's audio filters to apply ReplayGain in
+// mode.
+func (p *Player) setReplayGainMode(mode string) {
+	for _, d := range p.decks {
+		d.setReplayGainMode(mode)
+	}
+}
+
+// watch accounts time spent playing, and starts crossfades as tracks
+// come to an end, until player is closed.
+func (p *Player) watch() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		daemon.Lock()
+		if p.closed {
+			daemon.Unlock()
+			return
+		}
+		if p.state() == "play" {
+			p.playtime += now.Sub(last)
+		}
+		last = now
+		if ok, pos := p.position(); ok && time.Duration(pos) > p.listen.listened {
+			p.listen.listened = time.Duration(pos)
+			p.skipped = 0
+		}
+		p.maybeCrossfade()
+		p.partition.radio.maybeExtend()
+		daemon.Unlock()
+	}
+}
+
+// maybeCrossfade fades into the next track when the current one is within
+// the crossfade duration of its end.
+func (p *Player) maybeCrossfade() {
+	duration := time.Duration(p.partition.options.crossfade) * time.Second
+	if duration <= 0 || p.state() != "play" {
+		return
+	}
+	if stateOf(p.other().pipe) != gst.STATE_NULL {
+		return // still fading
+	}
+
+	remaining, ok := p.remaining()
+	if !ok || remaining > duration {
+		return
+	}
+
+	p.partition.playlist.crossfadeNext(remaining)
+}
+
+// remaining returns how much of the current track is left to play, if
+// it's known.
+func (p *Player) remaining() (time.Duration, bool) {
+	d := p.current()
+	if d.track == "" {
+		return 0, false
+	}
+	ok, pos := p.position()
+	if !ok || d.duration == 0 {
+		return 0, false
+	}
+
+	return d.duration - time.Duration(pos), true
+}
+
+// newMixer makes a player's mixer, adding up what its decks play into the
+// audio outputs.
+func newMixer(name string, outputs []*audioOutput) *gst.Pipeline {
+	mixer := gst.NewPipeline(name + "-mixer")
+	adder := gst.ElementFactoryMake("adder", name+"-adder")
+	out := newOutputBin(name+"-outputs", outputs)
+	mixer.Add(adder, out.AsElement())
+	adder.Link(out.AsElement())
+
+	for i := range [2]int{} {
+		prefix := fmt.Sprintf("%s-deck%d-", name, i)
+		src := gst.ElementFactoryMake("interaudiosrc", prefix+"src")
+		src.SetProperty("channel", deckChannel(name, i))
+		convert := gst.ElementFactoryMake("audioconvert", prefix+"convert")
+		resample := gst.ElementFactoryMake("audioresample", prefix+"resample")
+		mixer.Add(src, convert, resample)
+		src.Link(convert, resample, adder)
+	}
+
+	return mixer
+}
+
+// newDeck allocates the ith deck of p, applying ReplayGain in
+// replayGainMode.
+func newDeck(p *Player, i int, replayGainMode string) *deck {
+	d := &deck{player: p}
+
+	d.pipe = gst.ElementFactoryMake("playbin2", deckChannel(p.name, i))
+	d.sink = gst.ElementFactoryMake("interaudiosink", deckChannel(p.name, i)+"-sink")
+	d.sink.SetProperty("channel", deckChannel(p.name, i))
+	d.pipe.SetProperty("audio-sink", d.sink)
+	filter, rgvolume := newReplayGainFilter(replayGainMode, p.partition.playlist.Random)
+	d.pipe.SetProperty("audio-filter", filter)
+	d.rgvolume = rgvolume
+
+	d.bus = d.pipe.GetBus()
+	d.bus.AddSignalWatch()
+	d.bus.Connect("message", (*deck).onMessage, d)
+	d.bus.EnableSyncMessageEmission()
+	d.bus.Connect("sync-message::element", (*deck).onSyncMessage, d)
+
+	return d
+}
+
+// NewPlayer allocates a new Player for part, playing to its outputs and
+// applying ReplayGain in its mode.
+func NewPlayer(part *partition) *Player {
+	p := &Player{partition: part, name: "partition-" + part.name, volume: 100}
+	p.mixer = newMixer(p.name, part.outputs)
+	for i := range p.decks {
+		p.decks[i] = newDeck(p, i, part.options.replayGainMode)
+	}
+	go p.watch()
+
+	return p
+}
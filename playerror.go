@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/amir/gmpd/util"
+	"github.com/amir/gst"
+)
+
+// playbackError is a classified playback error.
+type playbackError struct {
+	kind    util.PlaybackErrorKind
+	message string
+}
+
+// Error implements error.
+func (e *playbackError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.message)
+}
+
+// classifyPlaybackError classifies the playback error message, with its
+// debug information.
+func classifyPlaybackError(message, debug string) *playbackError {
+	return &playbackError{util.ClassifyPlaybackError(message, debug), message}
+}
+
+// onError handles a playback error of deck, retrying the track with a fresh
+// stream URL when that may help, and skipping it after maxPlayFailures
+// failures.
+func (d *deck) onError(err *playbackError) {
+	p := d.player
+	log.Printf("Playing %s: %s", d.track, err)
+	if d != p.current() {
+		// The track was fading out, the next one plays on.
+		d.stop()
+		return
+	}
+	if err.kind == util.ExpiredURLError {
+		daemon.cp.InvalidateStreamURL(d.track)
+		stats := daemon.cp.StreamURLCacheStats()
+		log.Printf("Stream URL for %s refused, stream URL cache hit rate: %.2f",
+			d.track, stats.HitRate())
+	}
+
+	p.failures++
+	if err.kind.Retriable() && p.failures < *maxPlayFailures {
+		_, pos := d.pipe.GetPosition()
+		d.stop()
+		go d.retry(p.fadeGen, d.track, pos)
+		return
+	}
+	p.lastError = err.Error()
+	p.skip()
+}
+
+// retry plays deck's track again from pos, where it failed, with a fresh
+// stream URL. The URL is fetched, and the track prerolled, without holding
+// the daemon's lock; the retry is dropped if the player moved on meanwhile,
+// as told by gen.
+func (d *deck) retry(gen int, track string, pos int64) {
+	url, err := streamURL(track)
+
+	daemon.Lock()
+	defer daemon.Unlock()
+	p := d.player
+	if gen != p.fadeGen || d != p.current() {
+		return
+	}
+	if err != nil {
+		log.Printf("Retrying %s: %s", track, err)
+		p.lastError = err.Error()
+		p.skip()
+		p.partition.notify(IdlePlayer)
+		return
+	}
+	d.pipe.SetProperty("uri", url)
+	if pos <= 0 {
+		d.pipe.SetState(gst.STATE_PLAYING)
+		return
+	}
+	d.pipe.SetState(gst.STATE_PAUSED)
+	go d.seekWhenReady(gen, pos, gst.STATE_PLAYING)
+}
+
+// skip skips the current track, which failed to play, stopping when every
+// track in the playlist failed in a row.
+func (p *Player) skip() {
+	p.skipped++
+	playlist := p.partition.playlist
+	if p.skipped >= playlist.Len() || playlist.advance(playlist.NextPosition()) != nil {
+		p.stop()
+	}
+}
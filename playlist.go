@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"github.com/amir/gmpd/queue"
+)
+
+// Playlist represents a partition's current playlist.
+type Playlist struct {
+	queue.Queue
+	partition *partition // owning the playlist
+}
+
+// setCurrent makes the track at pos the current one, remembering it for
+// radio mode.
+func (p *Playlist) setCurrent(pos int) {
+	p.SetCurrent(pos)
+	p.partition.radio.remember(p.Items[pos].Track)
+}
+
+// playPosition plays the track at pos in playlist
+func (p *Playlist) playPosition(pos int) error {
+	track, err := p.TrackAtPosition(pos)
+	if err != nil {
+		return err
+	}
+	url, err := streamURL(track)
+	if err != nil {
+		return err
+	}
+	p.partition.player.play(track, url)
+	p.setCurrent(pos)
+
+	return nil
+}
+
+// playNext plays the track following the current one as it ends, telling
+// radio mode if the playlist ran out.
+func (p *Playlist) playNext() {
+	pos := p.AutoNextPosition()
+	if pos == -1 && p.NextPosition() == -1 {
+		p.partition.radio.ranOut()
+	}
+	p.advance(pos)
+}
+
+// advance plays the track at pos after the current one, once readied by
+// Advance. A pos of -1 plays nothing.
+func (p *Playlist) advance(pos int) error {
+	return p.playPosition(p.Advance(pos))
+}
+
+// crossfadeNext fades into the next track in playlist over duration,
+// unless it's on the same album as the current one, and reports whether
+// it did.
+func (p *Playlist) crossfadeNext(duration time.Duration) bool {
+	pos := p.AutoNextPosition()
+	next, err := p.TrackAtPosition(pos)
+	if err != nil {
+		return false
+	}
+	current, err := p.CurrentTrack()
+	if err != nil {
+		return false
+	}
+	if sameAlbum(current, next) {
+		return false
+	}
+
+	url, err := streamURL(next)
+	if err != nil {
+		return false
+	}
+	pos = p.Advance(pos)
+	p.partition.player.crossfade(next, url, duration)
+	p.setCurrent(pos)
+	p.partition.notify(IdlePlayer)
+
+	return true
+}
+
+// sameAlbum reports whether two tracks are on the same album, in which
+// case they are meant to play gaplessly rather than crossfade.
+func sameAlbum(a, b string) bool {
+	ta, err := findTrack(a)
+	if err != nil || ta.AlbumID == "" {
+		return false
+	}
+	tb, err := findTrack(b)
+	if err != nil {
+		return false
+	}
+
+	return ta.AlbumID == tb.AlbumID
+}
@@ -0,0 +1,316 @@
+// Package queue keeps a play queue: its tracks, the current one, and the
+// order they play in under MPD's priorities and random, repeat, single and
+// consume modes. It plays nothing itself.
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// MaxPriority is the highest priority of a queued track.
+const MaxPriority = 255
+
+var ErrNoSuchSong = errors.New("track does not exist")
+
+// Item represents a track in the queue.
+type Item struct {
+	ID      int    // song ID, stable while the track is queued
+	Track   string // track ID
+	Prio    int    // priority, 0 to MaxPriority
+	Version int    // queue version at which the item last changed
+	played  bool   // played since queued, or since its priority changed
+}
+
+// Queue represents a play queue.
+type Queue struct {
+	Items    []*Item
+	Position int  // current position
+	Version  int  // incremented each time the queue changes
+	Random   bool // play in random order?
+	Repeat   bool // start over once the end is reached?
+	Single   bool // stop after the current track, or repeat it?
+	Consume  bool // remove tracks once they're played?
+
+	lastID     int   // last song ID handed out
+	randomNext *Item // next item in random order, once chosen
+}
+
+// TrackPosition returns track's last position in queue, or -1
+func (q *Queue) TrackPosition(track string) int {
+	index := -1
+	for i, item := range q.Items {
+		if item.Track == track {
+			index = i
+		}
+	}
+
+	return index
+}
+
+// IDPosition returns position of the song with id in queue, or -1
+func (q *Queue) IDPosition(id int) int {
+	for i, item := range q.Items {
+		if item.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// String returns MPD-response-formatted representation of the queue
+func (q *Queue) String() string {
+	buffer := bytes.NewBufferString("")
+
+	for p, item := range q.Items {
+		fmt.Fprintf(buffer, "%d:file: %s\n", p, item.Track)
+	}
+
+	return buffer.String()
+}
+
+// ItemAtPosition returns the item at provided position in queue
+func (q *Queue) ItemAtPosition(pos int) (*Item, error) {
+	if pos >= 0 && pos < q.Len() {
+		return q.Items[pos], nil
+	}
+	return nil, ErrNoSuchSong
+}
+
+// TrackAtPosition returns track ID at provided position in queue
+func (q *Queue) TrackAtPosition(pos int) (track string, err error) {
+	item, err := q.ItemAtPosition(pos)
+	if err != nil {
+		return "", err
+	}
+	return item.Track, nil
+}
+
+// CurrentTrack returns current track in queue
+func (q *Queue) CurrentTrack() (track string, err error) {
+	if q.Len() > 0 {
+		return q.Items[q.Position].Track, nil
+	}
+
+	return "", errors.New("playlist is empty")
+}
+
+// NextPosition returns the position of the track to play after the
+// current one, or -1 if there is none. Unplayed tracks with a priority go
+// first, highest first, then tracks follow in queue order, or in random
+// order in random mode.
+func (q *Queue) NextPosition() int {
+	next, prio := -1, 0
+	for i := 1; i < q.Len(); i++ {
+		pos := (q.Position + i) % q.Len()
+		item := q.Items[pos]
+		if !item.played && item.Prio > prio {
+			next, prio = pos, item.Prio
+		}
+	}
+	if next != -1 {
+		return next
+	}
+
+	if q.Random {
+		var unplayed []int
+		for pos, item := range q.Items {
+			if !item.played && pos != q.Position {
+				if item == q.randomNext {
+					return pos
+				}
+				unplayed = append(unplayed, pos)
+			}
+		}
+		if len(unplayed) == 0 {
+			return -1
+		}
+		next := unplayed[rand.Intn(len(unplayed))]
+		q.randomNext = q.Items[next]
+		return next
+	}
+
+	if q.Position+1 < q.Len() {
+		return q.Position + 1
+	}
+	if q.Repeat && q.Len() > 0 {
+		return 0
+	}
+	return -1
+}
+
+// AutoNextPosition returns the position of the track to play once the
+// current one ends, or -1 if playback stops. In single mode, that's the
+// current track again if repeating, or none.
+func (q *Queue) AutoNextPosition() int {
+	if q.Single {
+		if q.Repeat && q.Len() > 0 {
+			return q.Position
+		}
+		return -1
+	}
+
+	return q.NextPosition()
+}
+
+// SetCurrent makes the track at pos the current one. Once every track was
+// played in random order, a new round starts when repeating.
+func (q *Queue) SetCurrent(pos int) {
+	q.Position = pos
+	q.Items[pos].played = true
+	q.randomNext = nil
+
+	if !q.Repeat {
+		return
+	}
+	for _, item := range q.Items {
+		if !item.played {
+			return
+		}
+	}
+	for i, item := range q.Items {
+		item.played = i == pos
+	}
+}
+
+// Advance readies the track at pos to play after the current one, and
+// returns its position once ready. A track jumping the queue on its
+// priority is moved to follow the current one, for queue order to resume
+// after it, and the current track is removed in consume mode. A pos of -1
+// readies nothing.
+func (q *Queue) Advance(pos int) int {
+	pos = q.follow(pos)
+	if q.Consume {
+		pos = q.consumeCurrent(pos)
+	}
+
+	return pos
+}
+
+// follow moves the track at pos to follow the current one, if it's an
+// unplayed track with a priority, out of queue order, and returns its
+// position once moved. Random mode has no queue order to resume.
+func (q *Queue) follow(pos int) int {
+	item, err := q.ItemAtPosition(pos)
+	if err != nil || q.Random || item.played || item.Prio == 0 || pos == q.Position {
+		return pos
+	}
+
+	to := q.Position + 1
+	if pos < q.Position {
+		to = q.Position
+		q.Position--
+	}
+	if pos == to {
+		return pos
+	}
+	q.Items = append(q.Items[:pos], q.Items[pos+1:]...)
+	q.Items = append(q.Items[:to], append([]*Item{item}, q.Items[to:]...)...)
+
+	q.Version++
+	from, until := to, pos
+	if pos < to {
+		from, until = pos, to
+	}
+	for _, item := range q.Items[from : until+1] {
+		item.Version = q.Version
+	}
+
+	return to
+}
+
+// consumeCurrent removes the current track, unless it's the one to play
+// next at pos, and returns the position of the next one once removed
+func (q *Queue) consumeCurrent(next int) int {
+	current := q.Position
+	if next == current || current >= q.Len() {
+		return next
+	}
+	q.Remove(current)
+	if next > current {
+		next--
+	}
+
+	return next
+}
+
+// Touch records that item changed, in a new version of queue
+func (q *Queue) Touch(item *Item) {
+	q.Version++
+	item.Version = q.Version
+}
+
+// ChangesSince returns the positions of the items changed since version
+func (q *Queue) ChangesSince(version int) []int {
+	var positions []int
+	for pos, item := range q.Items {
+		if item.Version > version {
+			positions = append(positions, pos)
+		}
+	}
+
+	return positions
+}
+
+// Add adds a new track to queue, and returns its song ID
+func (q *Queue) Add(track string) int {
+	return q.Insert(track, q.Len())
+}
+
+// Insert inserts track at pos in queue, returning its ID. The tracks
+// following it change position, so they count as changed.
+func (q *Queue) Insert(track string, pos int) int {
+	q.lastID++
+	item := &Item{ID: q.lastID, Track: track}
+	q.Items = append(q.Items, nil)
+	copy(q.Items[pos+1:], q.Items[pos:])
+	q.Items[pos] = item
+	if pos <= q.Position && q.Len() > 1 {
+		q.Position++
+	}
+	q.Version++
+	for _, item := range q.Items[pos:] {
+		item.Version = q.Version
+	}
+	return q.lastID
+}
+
+// Remove removes the track at pos from queue. The tracks following it
+// change position, so they count as changed.
+func (q *Queue) Remove(pos int) {
+	q.Items = append(q.Items[:pos], q.Items[pos+1:]...)
+	if pos < q.Position || q.Position > 0 && q.Position >= q.Len() {
+		q.Position--
+	}
+	q.Version++
+	for _, item := range q.Items[pos:] {
+		item.Version = q.Version
+	}
+}
+
+// SetPriority sets the priority of the track at pos, so that it's played
+// again before tracks with a lower priority
+func (q *Queue) SetPriority(pos, prio int) {
+	q.Items[pos].Prio = prio
+	q.Items[pos].played = pos == q.Position
+	q.Touch(q.Items[pos])
+}
+
+// SetRandom turns random mode on or off, starting a new round of random
+// order when it's turned on
+func (q *Queue) SetRandom(random bool) {
+	if random && !q.Random {
+		for pos, item := range q.Items {
+			item.played = pos == q.Position
+		}
+	}
+	q.Random = random
+}
+
+// Len returns number of tracks in queue
+func (q *Queue) Len() int {
+	return len(q.Items)
+}
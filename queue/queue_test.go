@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newQueue returns a queue of tracks, playing the first one.
+func newQueue(tracks ...string) *Queue {
+	q := &Queue{}
+	for _, track := range tracks {
+		q.Add(track)
+	}
+	q.SetCurrent(0)
+
+	return q
+}
+
+// playOrder returns the tracks q plays from its current one until it
+// stops, or until it played limit tracks.
+func playOrder(q *Queue, limit int) []string {
+	order := []string{q.Items[q.Position].Track}
+	for len(order) < limit {
+		pos := q.Advance(q.AutoNextPosition())
+		if pos == -1 {
+			break
+		}
+		q.SetCurrent(pos)
+		order = append(order, q.Items[pos].Track)
+	}
+
+	return order
+}
+
+func TestPriorityResumesQueueOrder(t *testing.T) {
+	q := newQueue("A", "B", "C", "D")
+	q.SetPriority(3, 1)
+
+	got := playOrder(q, 10)
+	want := []string{"A", "D", "B", "C"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+	if got := q.TrackPosition("D"); got != 1 {
+		t.Errorf("D moved to position %d, want 1", got)
+	}
+}
+
+func TestPriorityBeforeCurrentResumesQueueOrder(t *testing.T) {
+	q := newQueue("A", "B", "C", "D")
+	q.SetCurrent(1)
+	q.SetPriority(0, 1)
+
+	got := playOrder(q, 10)
+	want := []string{"B", "A", "C", "D"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+}
+
+func TestPriorityHighestFirst(t *testing.T) {
+	q := newQueue("A", "B", "C", "D", "E")
+	q.SetPriority(2, 1)
+	q.SetPriority(4, 2)
+
+	got := playOrder(q, 10)
+	want := []string{"A", "E", "C", "B", "D"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	q := newQueue("A", "B", "C")
+	q.Repeat = true
+
+	got := playOrder(q, 7)
+	want := []string{"A", "B", "C", "A", "B", "C", "A"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+}
+
+func TestSingle(t *testing.T) {
+	q := newQueue("A", "B", "C")
+	q.Single = true
+	if got := playOrder(q, 3); !reflect.DeepEqual(got, []string{"A"}) {
+		t.Errorf("Play order = %v, want [A]", got)
+	}
+	if got := q.NextPosition(); got != 1 {
+		t.Errorf("NextPosition() = %d, want 1, single mode only stops playback", got)
+	}
+
+	q.Repeat = true
+	if got := playOrder(q, 3); !reflect.DeepEqual(got, []string{"A", "A", "A"}) {
+		t.Errorf("Play order = %v, want [A A A]", got)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	q := newQueue("A", "B", "C")
+	q.Consume = true
+
+	got := playOrder(q, 10)
+	want := []string{"A", "B", "C"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+	// The last track is removed too once playback stops.
+	if q.Len() != 0 {
+		t.Errorf("%d tracks left, want none", q.Len())
+	}
+}
+
+func TestConsumeRepeatSingle(t *testing.T) {
+	q := newQueue("A", "B")
+	q.Consume, q.Repeat, q.Single = true, true, true
+
+	got := playOrder(q, 3)
+	want := []string{"A", "A", "A"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Play order = %v, want %v", got, want)
+	}
+	if q.Len() != 2 {
+		t.Errorf("%d tracks left, want 2, a repeated track isn't consumed", q.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		current, remove int
+		wantTrack       string
+		wantPosition    int
+	}{
+		{2, 0, "C", 1}, // before the current track
+		{1, 3, "B", 1}, // after it
+		{1, 1, "C", 1}, // the current track, the next one follows
+		{3, 3, "C", 2}, // the last, current track
+	}
+	for _, test := range tests {
+		q := newQueue("A", "B", "C", "D")
+		q.SetCurrent(test.current)
+		version := q.Version
+		q.Remove(test.remove)
+
+		if q.Len() != 3 {
+			t.Errorf("Remove(%d): %d tracks left, want 3", test.remove, q.Len())
+		}
+		track, _ := q.CurrentTrack()
+		if q.Position != test.wantPosition || track != test.wantTrack {
+			t.Errorf("Remove(%d) with %d current: current = %d %s, want %d %s",
+				test.remove, test.current, q.Position, track, test.wantPosition, test.wantTrack)
+		}
+		if changed := q.ChangesSince(version); len(changed) != q.Len()-test.remove {
+			t.Errorf("Remove(%d): changed %v, want the tracks following it", test.remove, changed)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		current, insert int
+		wantTrack       string
+		wantPosition    int
+	}{
+		{2, 0, "C", 3}, // before the current track
+		{1, 3, "B", 1}, // after it
+		{1, 1, "B", 2}, // at the current track, which follows it
+		{1, 4, "B", 1}, // last
+	}
+	for _, test := range tests {
+		q := newQueue("A", "B", "C", "D")
+		q.SetCurrent(test.current)
+		version := q.Version
+		id := q.Insert("E", test.insert)
+
+		if q.Len() != 5 || q.IDPosition(id) != test.insert {
+			t.Errorf("Insert(%d): %d tracks, E at %d, want 5, %d",
+				test.insert, q.Len(), q.IDPosition(id), test.insert)
+		}
+		track, _ := q.CurrentTrack()
+		if q.Position != test.wantPosition || track != test.wantTrack {
+			t.Errorf("Insert(%d) with %d current: current = %d %s, want %d %s",
+				test.insert, test.current, q.Position, track, test.wantPosition, test.wantTrack)
+		}
+		if changed := q.ChangesSince(version); len(changed) != q.Len()-test.insert {
+			t.Errorf("Insert(%d): changed %v, want it and the tracks following it", test.insert, changed)
+		}
+	}
+}
+
+func TestInsertIntoEmptyQueue(t *testing.T) {
+	q := &Queue{}
+	q.Insert("A", 0)
+	if track, err := q.CurrentTrack(); err != nil || track != "A" || q.Position != 0 {
+		t.Errorf("Insert(0) into an empty queue: current = %d %s, want 0 A", q.Position, track)
+	}
+}
+
+func TestRemoveLastTrack(t *testing.T) {
+	q := newQueue("A")
+	q.Remove(0)
+	if q.Len() != 0 || q.Position != 0 {
+		t.Errorf("Remove(0): %d tracks, position %d, want 0, 0", q.Len(), q.Position)
+	}
+	if _, err := q.CurrentTrack(); err == nil {
+		t.Error("CurrentTrack() succeeded on an empty queue")
+	}
+}
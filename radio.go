@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// radioLead is how long before the playlist runs out radio mode tops
+	// it up.
+	radioLead = 30 * time.Second
+	// radioBatch is the number of tracks radio mode adds at a time.
+	radioBatch = 10
+	// radioHistoryLength is the number of recent tracks radio mode won't
+	// add again.
+	radioHistoryLength = 200
+	// radioRetryDelay is how long radio mode waits before fetching tracks
+	// again, when the last fetch failed or added none.
+	radioRetryDelay = 10 * time.Second
+)
+
+// radio keeps the playlist going once it runs out, with tracks similar to
+// the last one played.
+type radio struct {
+	partition *partition // whose playlist the radio keeps going
+
+	enabled  bool
+	fetching bool      // are tracks being fetched?
+	waiting  bool      // did the playlist run out, waiting for tracks?
+	retryAt  time.Time // when to fetch again after a failed fetch
+	history  []string  // recently played or added track IDs, oldest first
+}
+
+// remember adds track to radio's history.
+func (r *radio) remember(track string) {
+	r.history = append(r.history, track)
+	if len(r.history) > radioHistoryLength {
+		r.history = r.history[len(r.history)-radioHistoryLength:]
+	}
+}
+
+// recent reports whether track is in radio's history.
+func (r *radio) recent(track string) bool {
+	for _, t := range r.history {
+		if t == track {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ranOut tells radio the playlist ran out, for playback to resume once
+// tracks are added.
+func (r *radio) ranOut() {
+	r.waiting = r.enabled
+}
+
+// maybeExtend starts fetching tracks seeded from the current one, when
+// it's the last in the playlist and within radioLead of its end, or when
+// the playlist ran out before tracks were added.
+func (r *radio) maybeExtend() {
+	if !r.enabled || r.fetching || time.Now().Before(r.retryAt) {
+		return
+	}
+	playlist := r.partition.playlist
+	if !r.waiting {
+		if r.partition.player.state() != "play" || playlist.NextPosition() != -1 {
+			return
+		}
+		remaining, ok := r.partition.player.remaining()
+		if !ok || remaining > radioLead {
+			return
+		}
+	}
+	seed, err := playlist.CurrentTrack()
+	if err != nil && len(r.history) > 0 {
+		// The last track was consumed.
+		seed, err = r.history[len(r.history)-1], nil
+	}
+	if err != nil {
+		return
+	}
+
+	r.fetching = true
+	history := make([]string, len(r.history))
+	copy(history, r.history)
+	go r.extend(seed, history)
+}
+
+// extend fetches tracks seeded from seed, and adds those not in history to
+// the playlist. Playback resumes with them if the playlist ran out while
+// they were fetched. Fetching is retried after radioRetryDelay if no track
+// was added.
+func (r *radio) extend(seed string, history []string) {
+	tracks, err := daemon.cp.RadioTracks(seed, radioBatch, history)
+
+	daemon.Lock()
+	defer daemon.Unlock()
+	r.fetching = false
+	if err != nil {
+		log.Printf("Radio seeded from %s: %s", seed, err)
+		r.retryAt = time.Now().Add(radioRetryDelay)
+		return
+	}
+	if !r.enabled {
+		return
+	}
+
+	playlist := r.partition.playlist
+	first := playlist.Len()
+	for _, track := range tracks {
+		id := trackFile(track)
+		if r.recent(id) {
+			continue
+		}
+		playlist.Add(id)
+		r.remember(id)
+	}
+	if playlist.Len() == first {
+		r.retryAt = time.Now().Add(radioRetryDelay)
+		return
+	}
+	r.partition.notify(IdlePlaylist)
+
+	if r.waiting {
+		r.waiting = false
+		if r.partition.player.state() == "stop" && playlist.playPosition(first) == nil {
+			r.partition.notify(IdlePlayer)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+
+	cp "github.com/amir/gmpd/contentprovider"
+	"github.com/amir/gst"
+)
+
+// ReplayGain modes
+const (
+	ReplayGainOff   = "off"
+	ReplayGainTrack = "track"
+	ReplayGainAlbum = "album"
+	ReplayGainAuto  = "auto"
+)
+
+var replayGainModes = map[string]bool{
+	ReplayGainOff:   true,
+	ReplayGainTrack: true,
+	ReplayGainAlbum: true,
+	ReplayGainAuto:  true,
+}
+
+// replayGainAlbumMode reports whether mode applies album rather than track
+// gain. Auto mode uses album gain unless tracks play in random order.
+func replayGainAlbumMode(mode string, random bool) bool {
+	return mode == ReplayGainAlbum || mode == ReplayGainAuto && !random
+}
+
+// replayGainFromTags returns the ReplayGain values in a tag message, if it
+// carries any.
+func replayGainFromTags(tags *gst.TagList) (cp.ReplayGain, bool) {
+	var rg cp.ReplayGain
+	if tags == nil {
+		return rg, false
+	}
+	rg.TrackGain, rg.HasTrack = tags.GetDouble("replaygain-track-gain")
+	rg.AlbumGain, rg.HasAlbum = tags.GetDouble("replaygain-album-gain")
+	rg.TrackPeak, _ = tags.GetDouble("replaygain-track-peak")
+	rg.AlbumPeak, _ = tags.GetDouble("replaygain-album-peak")
+
+	return rg, rg.HasTrack || rg.HasAlbum
+}
+
+// replayGainFallback returns the gain to apply to a stream without
+// ReplayGain tags, from its cached values. rgvolume adds its pre-amp to
+// the fallback gain, so preamp is left out, but unless limit is off, the
+// gain is lowered so the peak doesn't clip once it's added.
+func replayGainFallback(rg cp.ReplayGain, album bool, preamp float64, limit bool) float64 {
+	gain, peak, ok := rg.TrackGain, rg.TrackPeak, rg.HasTrack
+	if album && rg.HasAlbum || !ok {
+		gain, peak, ok = rg.AlbumGain, rg.AlbumPeak, rg.HasAlbum
+	}
+	if !ok {
+		return 0
+	}
+
+	if limit && peak > 0 {
+		if max := -20*math.Log10(peak) - preamp; gain > max {
+			gain = max
+		}
+	}
+
+	return gain
+}
+
+// newReplayGainFilter makes the player's audio filter applying ReplayGain
+// in mode, as tracks play in random order or not, and returns it with its
+// rgvolume element, which is nil when mode is off.
+func newReplayGainFilter(mode string, random bool) (*gst.Bin, *gst.Element) {
+	bin := gst.NewBin("replaygain")
+	if mode == ReplayGainOff {
+		identity := gst.ElementFactoryMake("identity", "replaygain-identity")
+		bin.Add(identity)
+		bin.AddPad(gst.NewGhostPad("sink", identity.GetStaticPad("sink")).AsPad())
+		bin.AddPad(gst.NewGhostPad("src", identity.GetStaticPad("src")).AsPad())
+		return bin, nil
+	}
+
+	volume := gst.ElementFactoryMake("rgvolume", "replaygain-volume")
+	volume.SetProperty("album-mode", replayGainAlbumMode(mode, random))
+	volume.SetProperty("pre-amp", *replayGainPreamp)
+	limiter := gst.ElementFactoryMake("rglimiter", "replaygain-limiter")
+	limiter.SetProperty("enabled", *replayGainLimit)
+	convert := gst.ElementFactoryMake("audioconvert", "replaygain-convert")
+	bin.Add(volume, limiter, convert)
+	volume.Link(limiter, convert)
+	bin.AddPad(gst.NewGhostPad("sink", volume.GetStaticPad("sink")).AsPad())
+	bin.AddPad(gst.NewGhostPad("src", convert.GetStaticPad("src")).AsPad())
+
+	return bin, volume
+}
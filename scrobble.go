@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amir/gmpd/scrobbler"
+)
+
+// scrobbleRetryInterval is how often scrobbles that couldn't be submitted
+// are retried.
+const scrobbleRetryInterval = 5 * time.Minute
+
+// newScrobbler allocates a scrobbler submitting to the services enabled on
+// the command line, queueing scrobbles in the cache directory. It returns
+// nil if none is.
+func newScrobbler() (*scrobbler.Scrobbler, error) {
+	var services []scrobbler.Service
+	if *lastFM {
+		services = append(services, &scrobbler.LastFM{
+			Endpoint:   *lastFMEndpoint,
+			APIKey:     *lastFMAPIKey,
+			Secret:     *lastFMSecret,
+			SessionKey: *lastFMSessionKey,
+		})
+	}
+	if *listenBrainz {
+		services = append(services, &scrobbler.ListenBrainz{
+			Endpoint: *listenBrainzEndpoint,
+			Token:    *listenBrainzToken,
+		})
+	}
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	queue, err := scrobbler.OpenQueue(filepath.Join(*cacheDir, "scrobbles.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	return scrobbler.New(queue, services...), nil
+}
+
+// scrobblerTrack returns track as scrobbling services know it. Test tones
+// aren't scrobbled.
+func scrobblerTrack(track string) (scrobbler.Track, bool) {
+	if strings.HasPrefix(track, toneScheme) {
+		return scrobbler.Track{}, false
+	}
+	t, err := findTrack(track)
+	if err != nil {
+		return scrobbler.Track{}, false
+	}
+	millis, _ := strconv.Atoi(t.DurationMillis)
+
+	return scrobbler.Track{
+		Artist:      t.Artist,
+		Title:       t.Title,
+		Album:       t.Album,
+		AlbumArtist: t.AlbumArtist,
+		TrackNumber: t.TrackNumber,
+		Duration:    time.Duration(millis) * time.Millisecond,
+	}, true
+}
+
+// scrobbleNowPlaying announces track to scrobbling services.
+func scrobbleNowPlaying(track string) {
+	if daemon.scrobbler == nil {
+		return
+	}
+	if t, ok := scrobblerTrack(track); ok {
+		daemon.scrobbler.NowPlaying(t)
+	}
+}
+
+// scrobble scrobbles l to scrobbling services.
+func scrobble(l listen) {
+	if daemon.scrobbler == nil {
+		return
+	}
+	t, ok := scrobblerTrack(l.track)
+	if !ok {
+		return
+	}
+	if err := daemon.scrobbler.Scrobble(t, l.start); err != nil {
+		log.Printf("Queueing scrobble of %s: %s", l.track, err)
+	}
+}
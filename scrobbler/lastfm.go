@@ -0,0 +1,133 @@
+package scrobbler
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// LastFMEndpoint is Last.fm's API endpoint.
+const LastFMEndpoint = "https://ws.audioscrobbler.com/2.0/"
+
+// Last.fm error codes worth retrying after: those about authentication,
+// which may be fixed in the configuration, service offline, temporarily
+// unavailable, and rate limit exceeded.
+var lastFMRetryErrors = map[int]bool{4: true, 9: true, 10: true, 11: true,
+	16: true, 26: true, 29: true}
+
+// LastFM scrobbles to Last.fm, or any service implementing its API, as an
+// authenticated session of an API account.
+type LastFM struct {
+	Endpoint   string // defaults to LastFMEndpoint
+	APIKey     string
+	Secret     string // shared secret of the API account, signs calls
+	SessionKey string
+	Client     *http.Client // defaults to http.DefaultClient
+}
+
+// Name implements Service.
+func (l *LastFM) Name() string {
+	return "lastfm"
+}
+
+// NowPlaying implements Service.
+func (l *LastFM) NowPlaying(t Track) error {
+	params := url.Values{}
+	setLastFMTrack(params, "", t)
+
+	return l.call("track.updateNowPlaying", params)
+}
+
+// Scrobble implements Service.
+func (l *LastFM) Scrobble(scrobbles []Scrobble) error {
+	params := url.Values{}
+	for i, s := range scrobbles {
+		suffix := fmt.Sprintf("[%d]", i)
+		setLastFMTrack(params, suffix, s.Track)
+		params.Set("timestamp"+suffix, strconv.FormatInt(s.PlayedAt.Unix(), 10))
+	}
+
+	return l.call("track.scrobble", params)
+}
+
+// setLastFMTrack sets the parameters describing t, suffixed with suffix.
+func setLastFMTrack(params url.Values, suffix string, t Track) {
+	params.Set("artist"+suffix, t.Artist)
+	params.Set("track"+suffix, t.Title)
+	if t.Album != "" {
+		params.Set("album"+suffix, t.Album)
+	}
+	if t.AlbumArtist != "" {
+		params.Set("albumArtist"+suffix, t.AlbumArtist)
+	}
+	if t.TrackNumber > 0 {
+		params.Set("trackNumber"+suffix, strconv.Itoa(t.TrackNumber))
+	}
+	if t.Duration > 0 {
+		params.Set("duration"+suffix, strconv.Itoa(int(t.Duration.Seconds())))
+	}
+}
+
+// lastFMSignature signs the parameters of a call with secret.
+func lastFMSignature(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	h := md5.New()
+	for _, k := range keys {
+		h.Write([]byte(k + params.Get(k)))
+	}
+	h.Write([]byte(secret))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// call calls an API method.
+func (l *LastFM) call(method string, params url.Values) error {
+	endpoint := l.Endpoint
+	if endpoint == "" {
+		endpoint = LastFMEndpoint
+	}
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	params.Set("method", method)
+	params.Set("api_key", l.APIKey)
+	params.Set("sk", l.SessionKey)
+	params.Set("api_sig", lastFMSignature(params, l.Secret))
+	params.Set("format", "json")
+	resp, err := client.PostForm(endpoint, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case result.Error != 0 && lastFMRetryErrors[result.Error]:
+		return fmt.Errorf("%s: %s", method, result.Message)
+	case result.Error != 0:
+		return &RejectedError{l.Name(), result.Message}
+	case retryStatus(resp.StatusCode):
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	case resp.StatusCode >= 400:
+		return &RejectedError{l.Name(), resp.Status}
+	}
+
+	return nil
+}
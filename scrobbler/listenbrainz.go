@@ -0,0 +1,131 @@
+package scrobbler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ListenBrainzEndpoint is ListenBrainz's API root.
+const ListenBrainzEndpoint = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens to ListenBrainz, or any service implementing
+// its API, as the owner of a user token.
+type ListenBrainz struct {
+	Endpoint string // defaults to ListenBrainzEndpoint
+	Token    string
+	Client   *http.Client // defaults to http.DefaultClient
+}
+
+// listenBrainzListen is a listen, as submitted to ListenBrainz.
+type listenBrainzListen struct {
+	ListenedAt int64 `json:"listened_at,omitempty"`
+	Metadata   struct {
+		ArtistName     string                 `json:"artist_name"`
+		TrackName      string                 `json:"track_name"`
+		ReleaseName    string                 `json:"release_name,omitempty"`
+		AdditionalInfo map[string]interface{} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+// newListenBrainzListen makes the listen of t, started at listenedAt
+// unless it's the zero time.
+func newListenBrainzListen(t Track, listenedAt time.Time) listenBrainzListen {
+	var l listenBrainzListen
+	if !listenedAt.IsZero() {
+		l.ListenedAt = listenedAt.Unix()
+	}
+	l.Metadata.ArtistName = t.Artist
+	l.Metadata.TrackName = t.Title
+	l.Metadata.ReleaseName = t.Album
+	l.Metadata.AdditionalInfo = map[string]interface{}{
+		"submission_client": "gmpd",
+	}
+	if t.AlbumArtist != "" {
+		l.Metadata.AdditionalInfo["release_artist_name"] = t.AlbumArtist
+	}
+	if t.TrackNumber > 0 {
+		l.Metadata.AdditionalInfo["tracknumber"] = t.TrackNumber
+	}
+	if t.Duration > 0 {
+		l.Metadata.AdditionalInfo["duration_ms"] = int64(t.Duration / time.Millisecond)
+	}
+
+	return l
+}
+
+// Name implements Service.
+func (lb *ListenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+// NowPlaying implements Service.
+func (lb *ListenBrainz) NowPlaying(t Track) error {
+	return lb.submit("playing_now", []listenBrainzListen{newListenBrainzListen(t, time.Time{})})
+}
+
+// Scrobble implements Service.
+func (lb *ListenBrainz) Scrobble(scrobbles []Scrobble) error {
+	listens := make([]listenBrainzListen, len(scrobbles))
+	for i, s := range scrobbles {
+		listens[i] = newListenBrainzListen(s.Track, s.PlayedAt)
+	}
+	listenType := "import"
+	if len(listens) == 1 {
+		listenType = "single"
+	}
+
+	return lb.submit(listenType, listens)
+}
+
+// submit submits listens of listenType.
+func (lb *ListenBrainz) submit(listenType string, listens []listenBrainzListen) error {
+	endpoint := lb.Endpoint
+	if endpoint == "" {
+		endpoint = ListenBrainzEndpoint
+	}
+	client := lb.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"listen_type": listenType,
+		"payload":     listens,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/1/submit-listens",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+lb.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case retryStatus(resp.StatusCode):
+		return fmt.Errorf("%s listen: %s", listenType, resp.Status)
+	case resp.StatusCode >= 400:
+		var result struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return &RejectedError{lb.Name(), result.Error}
+	}
+
+	return nil
+}
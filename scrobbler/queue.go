@@ -0,0 +1,102 @@
+package scrobbler
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqlCreateQueue = `CREATE TABLE IF NOT EXISTS scrobbles (
+    id INTEGER PRIMARY KEY,
+    service VARCHAR(255) NOT NULL,
+    artist VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    album VARCHAR(255) NOT NULL,
+    albumArtist VARCHAR(255) NOT NULL,
+    trackNumber INTEGER NOT NULL,
+    duration INTEGER NOT NULL,
+    playedAt INTEGER NOT NULL)`
+
+// Queue keeps scrobbles in an SQLite database until they're submitted, so
+// that they outlive network outages and restarts.
+type Queue struct {
+	db *sql.DB
+}
+
+// OpenQueue opens the queue kept in the database at path, creating it if
+// needed.
+func OpenQueue(path string) (*Queue, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// An in-memory database only lives as long as its connection.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqlCreateQueue)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Queue{db: db}, nil
+}
+
+// Close closes the queue's database.
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// push queues s for service.
+func (q *Queue) push(service string, s Scrobble) error {
+	_, err := q.db.Exec(`INSERT INTO scrobbles(service, artist, title,
+	  album, albumArtist, trackNumber, duration, playedAt)
+	  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, service, s.Artist, s.Title, s.Album,
+		s.AlbumArtist, s.TrackNumber, int64(s.Duration/time.Millisecond),
+		s.PlayedAt.Unix())
+
+	return err
+}
+
+// pending returns up to n scrobbles queued for service, oldest first.
+func (q *Queue) pending(service string, n int) ([]Scrobble, error) {
+	rows, err := q.db.Query(`SELECT id, artist, title, album, albumArtist,
+	  trackNumber, duration, playedAt FROM scrobbles
+	  WHERE service = ? ORDER BY playedAt, id LIMIT ?`, service, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var scrobbles []Scrobble
+	for rows.Next() {
+		var s Scrobble
+		var duration, playedAt int64
+		err = rows.Scan(&s.id, &s.Artist, &s.Title, &s.Album, &s.AlbumArtist,
+			&s.TrackNumber, &duration, &playedAt)
+		if err != nil {
+			return nil, err
+		}
+		s.Duration = time.Duration(duration) * time.Millisecond
+		s.PlayedAt = time.Unix(playedAt, 0)
+		scrobbles = append(scrobbles, s)
+	}
+
+	return scrobbles, rows.Err()
+}
+
+// remove removes submitted scrobbles from the queue.
+func (q *Queue) remove(scrobbles []Scrobble) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, s := range scrobbles {
+		_, err = tx.Exec(`DELETE FROM scrobbles WHERE id = ?`, s.id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
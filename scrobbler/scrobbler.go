@@ -0,0 +1,175 @@
+// Package scrobbler submits what gmpd plays to scrobbling services, keeping
+// scrobbles in a queue until they're accepted.
+package scrobbler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// MinDuration is the duration of the shortest track scrobbled.
+	MinDuration = 30 * time.Second
+	// batchSize is the number of scrobbles submitted at a time.
+	batchSize = 50
+)
+
+// Track is a played track, as services know it.
+type Track struct {
+	Artist      string
+	Title       string
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	Duration    time.Duration
+}
+
+// Scrobble is a track that was listened to.
+type Scrobble struct {
+	Track
+	PlayedAt time.Time // when it started playing
+	id       int64     // ID in the queue
+}
+
+// Service is a scrobbling service.
+type Service interface {
+	// Name names the service in the queue, and in logs.
+	Name() string
+	// NowPlaying announces the track starting to play.
+	NowPlaying(t Track) error
+	// Scrobble submits scrobbles, up to batchSize of them.
+	Scrobble(scrobbles []Scrobble) error
+}
+
+// RejectedError is returned by a service refusing a submission, which
+// retrying won't help.
+type RejectedError struct {
+	Service string
+	Reason  string
+}
+
+// Error implements error.
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%s rejected submission: %s", e.Service, e.Reason)
+}
+
+// retryStatus reports whether a submission answered with an HTTP status
+// is worth retrying: the service failed or is busy, or the credentials are
+// wrong, which may be fixed in the configuration.
+func retryStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests ||
+		status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// Scrobbler submits scrobbles to services, queueing them while services
+// can't be reached.
+type Scrobbler struct {
+	services   []Service
+	queue      *Queue
+	nowPlaying chan Track
+	flush      chan struct{}
+}
+
+// New allocates a new Scrobbler, submitting to services and queueing in
+// queue.
+func New(queue *Queue, services ...Service) *Scrobbler {
+	return &Scrobbler{
+		services:   services,
+		queue:      queue,
+		nowPlaying: make(chan Track, 1),
+		flush:      make(chan struct{}, 1),
+	}
+}
+
+// NowPlaying announces the track starting to play, unless the previous
+// announcement is still pending, in which case it's replaced.
+func (s *Scrobbler) NowPlaying(t Track) {
+	for {
+		select {
+		case s.nowPlaying <- t:
+			return
+		default:
+		}
+		select {
+		case <-s.nowPlaying:
+		default:
+		}
+	}
+}
+
+// Scrobble queues a scrobble of t for every service, and has it submitted.
+// Tracks shorter than MinDuration aren't scrobbled.
+func (s *Scrobbler) Scrobble(t Track, playedAt time.Time) error {
+	if t.Duration < MinDuration {
+		return nil
+	}
+	for _, service := range s.services {
+		err := s.queue.push(service.Name(), Scrobble{Track: t, PlayedAt: playedAt})
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run makes announcements and submits queued scrobbles as they come, and
+// retries those that failed every interval.
+func (s *Scrobbler) Run(interval time.Duration) {
+	retry := time.NewTicker(interval)
+	defer retry.Stop()
+	s.submit()
+	for {
+		select {
+		case t := <-s.nowPlaying:
+			s.announce(t)
+		case <-s.flush:
+			s.submit()
+		case <-retry.C:
+			s.submit()
+		}
+	}
+}
+
+// announce announces t to every service.
+func (s *Scrobbler) announce(t Track) {
+	for _, service := range s.services {
+		if err := service.NowPlaying(t); err != nil {
+			log.Printf("Announcing to %s: %s", service.Name(), err)
+		}
+	}
+}
+
+// submit submits queued scrobbles to every service, leaving them queued
+// when a service fails, and dropping those it rejects.
+func (s *Scrobbler) submit() {
+	for _, service := range s.services {
+		for {
+			scrobbles, err := s.queue.pending(service.Name(), batchSize)
+			if err != nil {
+				log.Printf("Reading %s scrobbles: %s", service.Name(), err)
+				break
+			}
+			if len(scrobbles) == 0 {
+				break
+			}
+			err = service.Scrobble(scrobbles)
+			if rejected, ok := err.(*RejectedError); ok {
+				log.Printf("Dropping %d scrobbles: %s", len(scrobbles), rejected)
+			} else if err != nil {
+				log.Printf("Scrobbling to %s: %s", service.Name(), err)
+				break
+			}
+			if err = s.queue.remove(scrobbles); err != nil {
+				log.Printf("Removing %s scrobbles: %s", service.Name(), err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package scrobbler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var testTrack = Track{
+	Artist:      "Artist",
+	Title:       "Title",
+	Album:       "Album",
+	TrackNumber: 3,
+	Duration:    3 * time.Minute,
+}
+
+func newTestQueue(t *testing.T) *Queue {
+	q, err := OpenQueue(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestLastFMSignature(t *testing.T) {
+	params := url.Values{
+		"method":  {"track.scrobble"},
+		"api_key": {"key"},
+		"format":  {"json"},
+	}
+	// md5("api_keykeymethodtrack.scrobblesecret")
+	want := "d7a2d80e182cf1fea315ddc2d0bbfe44"
+	if got := lastFMSignature(params, "secret"); got != want {
+		t.Errorf("lastFMSignature() = %s, want %s", got, want)
+	}
+}
+
+func TestLastFMScrobble(t *testing.T) {
+	var got url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm
+		w.Write([]byte(`{"scrobbles":{}}`))
+	}))
+	defer server.Close()
+
+	l := &LastFM{Endpoint: server.URL, APIKey: "key", Secret: "secret", SessionKey: "sk"}
+	playedAt := time.Unix(1500000000, 0)
+	err := l.Scrobble([]Scrobble{{Track: testTrack, PlayedAt: playedAt}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"method":         "track.scrobble",
+		"api_key":        "key",
+		"sk":             "sk",
+		"artist[0]":      "Artist",
+		"track[0]":       "Title",
+		"album[0]":       "Album",
+		"trackNumber[0]": "3",
+		"duration[0]":    "180",
+		"timestamp[0]":   "1500000000",
+	}
+	for k, v := range expected {
+		if got.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+		}
+	}
+	signed := url.Values{}
+	for k, v := range got {
+		if k != "api_sig" {
+			signed[k] = v
+		}
+	}
+	if got.Get("api_sig") != lastFMSignature(signed, "secret") {
+		t.Errorf("api_sig = %s, want %s", got.Get("api_sig"), lastFMSignature(signed, "secret"))
+	}
+}
+
+func TestLastFMErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		rejected bool
+	}{
+		{http.StatusOK, `{"error":11,"message":"Service Offline"}`, false},
+		{http.StatusOK, `{"error":6,"message":"Invalid parameters"}`, true},
+		{http.StatusServiceUnavailable, ``, false},
+		{http.StatusBadRequest, ``, true},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		err := (&LastFM{Endpoint: server.URL}).NowPlaying(testTrack)
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%d %s: no error", test.status, test.body)
+			continue
+		}
+		if _, rejected := err.(*RejectedError); rejected != test.rejected {
+			t.Errorf("%d %s: rejected = %v, want %v", test.status, test.body, rejected, test.rejected)
+		}
+	}
+}
+
+func TestListenBrainzScrobble(t *testing.T) {
+	var got struct {
+		ListenType string               `json:"listen_type"`
+		Payload    []listenBrainzListen `json:"payload"`
+	}
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	lb := &ListenBrainz{Endpoint: server.URL, Token: "token"}
+	err := lb.Scrobble([]Scrobble{{Track: testTrack, PlayedAt: time.Unix(1500000000, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Token token" {
+		t.Errorf("Authorization = %q, want %q", auth, "Token token")
+	}
+	if got.ListenType != "single" || len(got.Payload) != 1 {
+		t.Fatalf("got %s listen of %d tracks, want single listen of 1", got.ListenType, len(got.Payload))
+	}
+	l := got.Payload[0]
+	if l.ListenedAt != 1500000000 || l.Metadata.ArtistName != "Artist" ||
+		l.Metadata.TrackName != "Title" || l.Metadata.ReleaseName != "Album" {
+		t.Errorf("got listen %+v", l)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := newTestQueue(t)
+	defer q.Close()
+
+	for i := 0; i < 3; i++ {
+		err := q.push("a", Scrobble{Track: testTrack, PlayedAt: time.Unix(int64(3-i), 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.push("b", Scrobble{Track: testTrack, PlayedAt: time.Unix(0, 0)})
+
+	pending, err := q.pending("a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].PlayedAt.Unix() != 1 || pending[1].PlayedAt.Unix() != 2 {
+		t.Fatalf("pending = %+v, want the 2 oldest", pending)
+	}
+	if pending[0].Track != testTrack {
+		t.Errorf("track = %+v, want %+v", pending[0].Track, testTrack)
+	}
+
+	q.remove(pending)
+	pending, _ = q.pending("a", 10)
+	if len(pending) != 1 || pending[0].PlayedAt.Unix() != 3 {
+		t.Errorf("pending after remove = %+v, want the newest", pending)
+	}
+	pending, _ = q.pending("b", 10)
+	if len(pending) != 1 {
+		t.Errorf("other service's pending = %+v, want 1", pending)
+	}
+}
+
+// fakeService is a Service failing while down, and rejecting everything
+// when rejecting.
+type fakeService struct {
+	down      bool
+	rejecting bool
+	scrobbled []Scrobble
+}
+
+func (f *fakeService) Name() string { return "fake" }
+
+func (f *fakeService) NowPlaying(t Track) error { return nil }
+
+func (f *fakeService) Scrobble(scrobbles []Scrobble) error {
+	if f.down {
+		return &url.Error{Op: "Post", URL: "fake", Err: http.ErrServerClosed}
+	}
+	if f.rejecting {
+		return &RejectedError{f.Name(), "rejected"}
+	}
+	f.scrobbled = append(f.scrobbled, scrobbles...)
+	return nil
+}
+
+func TestScrobblerRetries(t *testing.T) {
+	q := newTestQueue(t)
+	defer q.Close()
+	service := &fakeService{down: true}
+	s := New(q, service)
+
+	s.Scrobble(testTrack, time.Unix(1, 0))
+	s.Scrobble(Track{Title: "Short", Duration: time.Second}, time.Unix(2, 0))
+	s.submit()
+	if pending, _ := q.pending("fake", 10); len(pending) != 1 {
+		t.Fatalf("queued %d scrobbles while down, want 1", len(pending))
+	}
+
+	service.down = false
+	s.submit()
+	if len(service.scrobbled) != 1 || service.scrobbled[0].Title != "Title" {
+		t.Errorf("scrobbled %+v once up, want the queued scrobble", service.scrobbled)
+	}
+	if pending, _ := q.pending("fake", 10); len(pending) != 0 {
+		t.Errorf("%d scrobbles still queued, want none", len(pending))
+	}
+}
+
+func TestScrobblerDropsRejected(t *testing.T) {
+	q := newTestQueue(t)
+	defer q.Close()
+	s := New(q, &fakeService{rejecting: true})
+
+	s.Scrobble(testTrack, time.Unix(1, 0))
+	s.submit()
+	if pending, _ := q.pending("fake", 10); len(pending) != 0 {
+		t.Errorf("%d rejected scrobbles still queued, want none", len(pending))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// boolFlag formats b as an MPD status flag.
+func boolFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeStatus writes the MPD-response-formatted status of part's player,
+// playlist and options.
+func writeStatus(w io.Writer, part *partition) {
+	playlist, player := part.playlist, part.player
+	fmt.Fprintf(w, "partition: %s\n", part.name)
+	fmt.Fprintf(w, "volume: %d\n", player.volume)
+	fmt.Fprintf(w, "repeat: %d\n", boolFlag(playlist.Repeat))
+	fmt.Fprintf(w, "random: %d\n", boolFlag(playlist.Random))
+	fmt.Fprintf(w, "single: %d\n", boolFlag(playlist.Single))
+	fmt.Fprintf(w, "consume: %d\n", boolFlag(playlist.Consume))
+	if part.radio.enabled {
+		fmt.Fprint(w, "radio: 1\n")
+	}
+	fmt.Fprintf(w, "playlist: %d\n", playlist.Version)
+	fmt.Fprintf(w, "playlistlength: %d\n", playlist.Len())
+	fmt.Fprintf(w, "mixrampdb: %f\n", part.options.mixRampDB)
+	if !math.IsNaN(part.options.mixRampDelay) {
+		fmt.Fprintf(w, "mixrampdelay: %f\n", part.options.mixRampDelay)
+	}
+	if part.options.crossfade > 0 {
+		fmt.Fprintf(w, "xfade: %d\n", part.options.crossfade)
+	}
+
+	state := player.state()
+	fmt.Fprintf(w, "state: %s\n", state)
+	if state != "stop" {
+		if item, err := playlist.ItemAtPosition(playlist.Position); err == nil {
+			fmt.Fprintf(w, "song: %d\n", playlist.Position)
+			fmt.Fprintf(w, "songid: %d\n", item.ID)
+		}
+		duration := player.current().duration
+		if ok, pos := player.position(); ok {
+			elapsed := time.Duration(pos)
+			fmt.Fprintf(w, "time: %d:%d\n", int(elapsed.Seconds()), int(duration.Seconds()))
+			fmt.Fprintf(w, "elapsed: %.3f\n", elapsed.Seconds())
+		}
+		if bitrate := player.current().bitrate; bitrate > 0 {
+			fmt.Fprintf(w, "bitrate: %d\n", bitrate/1000)
+		}
+		if duration > 0 {
+			fmt.Fprintf(w, "duration: %.3f\n", duration.Seconds())
+		}
+		if format, ok := player.audioFormat(); ok {
+			fmt.Fprintf(w, "audio: %s\n", format)
+		}
+		next := playlist.AutoNextPosition()
+		if item, err := playlist.ItemAtPosition(next); err == nil {
+			fmt.Fprintf(w, "nextsong: %d\n", next)
+			fmt.Fprintf(w, "nextsongid: %d\n", item.ID)
+		}
+	}
+
+	if job := daemon.updater.job(); job != 0 {
+		fmt.Fprintf(w, "updating_db: %d\n", job)
+	}
+	if player.lastError != "" {
+		fmt.Fprintf(w, "error: %s\n", player.lastError)
+	}
+}
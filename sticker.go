@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	cp "github.com/amir/gmpd/contentprovider"
+	"github.com/amir/gmpd/util"
+)
+
+// stickerSong is the only type of object stickers are attached to.
+const stickerSong = "song"
+
+// ratingSticker is the sticker rating songs, from 0 to 10. It's synced to
+// Google Play Music's thumbs up and down.
+const ratingSticker = "rating"
+
+// Stickers kept from the history, which can't be set or deleted: how many
+// times a song was played, and when last, in seconds since the epoch.
+const (
+	playCountSticker  = "playcount"
+	lastPlayedSticker = "lastplayed"
+)
+
+var errBadRating = errors.New("rating must be from 0 to 10")
+
+// thumbsRating maps a rating sticker to Google Play Music thumbs: 8 and
+// over is a thumb up, 1 to 4 a thumb down.
+func thumbsRating(value string) (string, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 10 {
+		return "", errBadRating
+	}
+	switch {
+	case n >= 8:
+		return cp.RatingThumbUp, nil
+	case n >= 1 && n <= 4:
+		return cp.RatingThumbDown, nil
+	}
+
+	return cp.RatingNone, nil
+}
+
+// ratingValue maps Google Play Music thumbs to a rating sticker, or an
+// empty string if there are none.
+func ratingValue(thumbs string) string {
+	switch thumbs {
+	case cp.RatingThumbUp:
+		return "10"
+	case cp.RatingThumbDown:
+		return "2"
+	}
+
+	return ""
+}
+
+// songRating returns the rating sticker of a song given its thumbs. The
+// rating set locally is kept as long as it agrees with the thumbs.
+func songRating(uri, thumbs string) (string, bool) {
+	if thumbs == "" {
+		thumbs = cp.RatingNone
+	}
+	if value, err := daemon.cp.Sticker(stickerSong, uri, ratingSticker); err == nil {
+		if t, err := thumbsRating(value); err == nil && t == thumbs {
+			return value, true
+		}
+	}
+	value := ratingValue(thumbs)
+
+	return value, value != ""
+}
+
+// rateSong sets a song's rating sticker, and its thumbs, and returns an
+// ACK error if it fails.
+func rateSong(uri, value string) int {
+	thumbs, err := thumbsRating(value)
+	if err != nil {
+		return AckErrorArg
+	}
+	if daemon.cp.RateTrack(uri, thumbs) != nil {
+		return AckErrorSystem
+	}
+	if daemon.cp.SetSticker(stickerSong, uri, ratingSticker, value) != nil {
+		return AckErrorSystem
+	}
+
+	return 0
+}
+
+// unrateSong deletes a song's rating sticker, and its thumbs, and returns
+// an ACK error if it fails.
+func unrateSong(uri string) int {
+	if daemon.cp.RateTrack(uri, cp.RatingNone) != nil {
+		return AckErrorSystem
+	}
+	err := daemon.cp.DeleteSticker(stickerSong, uri, ratingSticker)
+	if err != nil && err != cp.ErrNoSticker {
+		return AckErrorSystem
+	}
+
+	return 0
+}
+
+// historyStickers returns the stickers of a song kept from the history.
+func historyStickers(uri string) ([]cp.Sticker, error) {
+	stats, err := daemon.cp.TrackPlayStats(uri)
+	if err != nil || stats.Plays == 0 {
+		return nil, err
+	}
+
+	return []cp.Sticker{
+		{URI: uri, Name: playCountSticker, Value: strconv.Itoa(stats.Plays)},
+		{URI: uri, Name: lastPlayedSticker, Value: strconv.FormatInt(stats.LastPlayed.Unix(), 10)},
+	}, nil
+}
+
+// songStickers returns the stickers of a song, its rating and those kept
+// from the history included.
+func songStickers(uri string, track cp.Track) ([]cp.Sticker, error) {
+	stored, err := daemon.cp.Stickers(stickerSong, uri)
+	if err != nil {
+		return nil, err
+	}
+	stickers, err := historyStickers(uri)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		if s.Name != ratingSticker {
+			stickers = append(stickers, s)
+		}
+	}
+	if value, ok := songRating(uri, track.Rating); ok {
+		stickers = append(stickers, cp.Sticker{URI: uri, Name: ratingSticker, Value: value})
+	}
+	sort.Slice(stickers, func(i, j int) bool { return stickers[i].Name < stickers[j].Name })
+
+	return stickers, nil
+}
+
+// findRatings returns the rating stickers of every rated song.
+func findRatings() ([]cp.Sticker, error) {
+	thumbs, err := daemon.cp.RatedTracks()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := daemon.cp.FindStickers(stickerSong, ratingSticker)
+	if err != nil {
+		return nil, err
+	}
+	uris := make(map[string]bool)
+	for uri := range thumbs {
+		uris[uri] = true
+	}
+	for _, s := range stored {
+		uris[s.URI] = true
+	}
+
+	var stickers []cp.Sticker
+	for uri := range uris {
+		if value, ok := songRating(uri, thumbs[uri]); ok {
+			stickers = append(stickers, cp.Sticker{URI: uri, Name: ratingSticker, Value: value})
+		}
+	}
+	sort.Slice(stickers, func(i, j int) bool { return stickers[i].URI < stickers[j].URI })
+
+	return stickers, nil
+}
+
+// processSticker processes the sticker command, and returns an ACK error
+// if it fails.
+func processSticker(tok *util.Tokenizer, w io.Writer) int {
+	command := tok.NextParam()
+	if tok.NextParam() != stickerSong {
+		return AckErrorArg
+	}
+	uri := tok.NextParam()
+	name := tok.NextParam()
+
+	if command == "find" {
+		if name == "" {
+			return AckErrorArg
+		}
+		var value string
+		switch tok.NextParam() {
+		case "":
+		case "=":
+			value = tok.NextParam()
+		default:
+			return AckErrorArg
+		}
+
+		// The songs below a directory, if one is given.
+		var songs map[string]bool
+		if uri != "" && uri != "/" {
+			songs = make(map[string]bool)
+			err := walkDirectory(uri, func(e dirEntry) {
+				if e.track != nil {
+					songs[trackFile(*e.track)] = true
+				}
+			})
+			if err != nil {
+				return AckErrorNoExist
+			}
+		}
+
+		var stickers []cp.Sticker
+		var err error
+		if name == ratingSticker {
+			stickers, err = findRatings()
+		} else {
+			stickers, err = daemon.cp.FindStickers(stickerSong, name)
+		}
+		if err != nil {
+			return AckErrorSystem
+		}
+		for _, s := range stickers {
+			if (songs == nil || songs[s.URI]) && (value == "" || s.Value == value) {
+				fmt.Fprintf(w, "file: %s\nsticker: %s=%s\n", s.URI, s.Name, s.Value)
+			}
+		}
+		return 0
+	}
+
+	track, err := findTrack(uri)
+	if err != nil {
+		return AckErrorNoExist
+	}
+
+	switch command {
+	case "get":
+		var value string
+		var ok bool
+		switch name {
+		case ratingSticker:
+			value, ok = songRating(uri, track.Rating)
+		case playCountSticker, lastPlayedSticker:
+			stickers, err := historyStickers(uri)
+			if err != nil {
+				return AckErrorSystem
+			}
+			for _, s := range stickers {
+				if s.Name == name {
+					value, ok = s.Value, true
+				}
+			}
+		default:
+			value, err = daemon.cp.Sticker(stickerSong, uri, name)
+			ok = err == nil
+		}
+		if !ok {
+			return AckErrorNoExist
+		}
+		fmt.Fprintf(w, "sticker: %s=%s\n", name, value)
+
+	case "set":
+		value := tok.NextParam()
+		if name == "" || value == "" || name == playCountSticker || name == lastPlayedSticker {
+			return AckErrorArg
+		}
+		if name == ratingSticker {
+			if ackError := rateSong(uri, value); ackError > 0 {
+				return ackError
+			}
+		} else if daemon.cp.SetSticker(stickerSong, uri, name, value) != nil {
+			return AckErrorSystem
+		}
+		daemon.sessions.notify(IdleSticker)
+
+	case "delete":
+		if name == playCountSticker || name == lastPlayedSticker {
+			return AckErrorArg
+		}
+		_, rated := songRating(uri, track.Rating)
+		deleted := false
+		if rated && (name == "" || name == ratingSticker) {
+			if ackError := unrateSong(uri); ackError > 0 {
+				return ackError
+			}
+			deleted = true
+		}
+		if name != ratingSticker {
+			err = daemon.cp.DeleteSticker(stickerSong, uri, name)
+			if err == nil {
+				deleted = true
+			} else if err != cp.ErrNoSticker {
+				return AckErrorSystem
+			}
+		}
+		if !deleted {
+			return AckErrorNoExist
+		}
+		daemon.sessions.notify(IdleSticker)
+
+	case "list":
+		stickers, err := songStickers(uri, track)
+		if err != nil {
+			return AckErrorSystem
+		}
+		for _, s := range stickers {
+			fmt.Fprintf(w, "sticker: %s=%s\n", s.Name, s.Value)
+		}
+
+	default:
+		return AckErrorArg
+	}
+
+	return 0
+}
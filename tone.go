@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cp "github.com/amir/gmpd/contentprovider"
+	"github.com/amir/gmpd/util"
+)
+
+// Test tones are queued as tone://FREQ[/SECONDS], and played from a WAV file
+// generated into the cache directory, so that playback can be exercised
+// without Google Play Music or an audio device.
+const (
+	toneScheme          = "tone://"
+	toneDefaultDuration = 10
+	toneRate            = 44100
+	// maxToneFiles is the number of tone WAV files kept in the cache
+	// directory, the oldest being removed to make room for new ones.
+	maxToneFiles = 16
+)
+
+// parseTone returns the frequency and duration of a test tone track ID.
+func parseTone(id string) (freq, seconds float64, ok bool) {
+	if !strings.HasPrefix(id, toneScheme) {
+		return 0, 0, false
+	}
+	fields := strings.SplitN(strings.TrimPrefix(id, toneScheme), "/", 2)
+	freq, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seconds = toneDefaultDuration
+	if len(fields) == 2 {
+		seconds, err = strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	if util.CheckTone(freq, seconds, toneRate) != nil {
+		return 0, 0, false
+	}
+
+	return freq, seconds, true
+}
+
+// findTrack returns a track's metadata, from the content provider unless
+// it's a test tone.
+func findTrack(id string) (cp.Track, error) {
+	freq, seconds, ok := parseTone(id)
+	if !ok {
+		return daemon.cp.FindTrack(id)
+	}
+
+	return cp.Track{
+		ID:             id,
+		Title:          fmt.Sprintf("%g Hz", freq),
+		Artist:         "gmpd",
+		Album:          "Test tones",
+		DurationMillis: strconv.Itoa(int(seconds * 1000)),
+	}, nil
+}
+
+// streamURL returns the URL to play a track from, generating the WAV file
+// of a test tone if needed.
+func streamURL(id string) (string, error) {
+	freq, seconds, ok := parseTone(id)
+	if !ok {
+		return daemon.cp.TrackStreamURL(id)
+	}
+
+	dir := filepath.Join(*cacheDir, "tones")
+	path := filepath.Join(dir, fmt.Sprintf("%g-%g.wav", freq, seconds))
+	if _, err := os.Stat(path); err == nil {
+		return "file://" + path, nil
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	pruneTones(dir)
+	// Written aside, so that a failed write leaves no partial tone behind.
+	f, err := ioutil.TempFile(dir, ".tone-")
+	if err != nil {
+		return "", err
+	}
+	err = util.WriteToneWAV(f, freq, seconds, toneRate)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return "file://" + path, nil
+}
+
+// pruneTones removes the oldest tone files in dir, leaving room for one
+// more within maxToneFiles.
+func pruneTones(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) < maxToneFiles {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files[:len(files)-maxToneFiles+1] {
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			log.Printf("Removing tone: %s", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// updater runs library synchronisation jobs in the background, one at a
+// time.
+type updater struct {
+	sync.Mutex
+	lastJob int // ID of the last job started
+	running int // ID of the running job, 0 if none is
+}
+
+// start starts a synchronisation job and returns its ID. A rescan
+// downloads the whole library instead of the changes since the last one.
+func (u *updater) start(rescan bool) (int, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.running != 0 {
+		return 0, errors.New("already updating")
+	}
+	u.lastJob++
+	u.running = u.lastJob
+	daemon.sessions.notify(IdleUpdate)
+	go u.run(u.running, rescan)
+
+	return u.running, nil
+}
+
+// run synchronises the library, and announces the outcome.
+func (u *updater) run(job int, rescan bool) {
+	result, err := daemon.cp.SyncLibrary(rescan)
+	if err != nil {
+		log.Printf("Library update %d failed: %s", job, err)
+	}
+
+	u.Lock()
+	u.running = 0
+	u.Unlock()
+
+	daemon.sessions.notify(IdleUpdate)
+	if result.Changed() {
+		daemon.sessions.notify(IdleDatabase)
+	}
+}
+
+// job returns the ID of the running job, 0 if there is none.
+func (u *updater) job() int {
+	u.Lock()
+	defer u.Unlock()
+	return u.running
+}
+
+// periodic starts an incremental synchronisation every interval.
+func (u *updater) periodic(interval time.Duration) {
+	for {
+		u.start(false)
+		time.Sleep(interval)
+	}
+}
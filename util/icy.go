@@ -0,0 +1,34 @@
+package util
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// icyMaxMetadataLength is the longest ICY metadata block, as its length is
+// sent as a single byte counting 16 byte units.
+const icyMaxMetadataLength = 255 * 16
+
+// IcyMetadata returns an ICY (SHOUTcast) metadata block announcing title,
+// or an empty block, a single zero byte, if title is empty.
+func IcyMetadata(title string) []byte {
+	if title == "" {
+		return []byte{0}
+	}
+
+	meta := "StreamTitle='" + strings.Replace(title, "'", "’", -1) + "';"
+	if len(meta) > icyMaxMetadataLength {
+		// Cut on a rune boundary, not to send invalid UTF-8.
+		cut := icyMaxMetadataLength - 2
+		for cut > 0 && !utf8.RuneStart(meta[cut]) {
+			cut--
+		}
+		meta = meta[:cut] + "';"
+	}
+	units := (len(meta) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], meta)
+
+	return block
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIcyMetadata(t *testing.T) {
+	block := IcyMetadata("Pink Floyd - Comfortably Numb")
+	meta := "StreamTitle='Pink Floyd - Comfortably Numb';"
+	if int(block[0])*16 != len(block)-1 {
+		t.Errorf("Length byte = %d, block length = %d", block[0], len(block))
+	}
+	if len(block)-1 < len(meta) || len(block)-1-len(meta) >= 16 {
+		t.Errorf("Block length = %d, want %d padded to 16", len(block)-1, len(meta))
+	}
+	if !bytes.HasPrefix(block[1:], []byte(meta)) {
+		t.Errorf("Block = %q, want prefix %q", block[1:], meta)
+	}
+}
+
+func TestIcyMetadataEmpty(t *testing.T) {
+	if block := IcyMetadata(""); !bytes.Equal(block, []byte{0}) {
+		t.Errorf("Block = %v, want [0]", block)
+	}
+}
+
+func TestIcyMetadataTooLong(t *testing.T) {
+	block := IcyMetadata(strings.Repeat("a", 5000))
+	if block[0] != 255 || len(block) != 1+255*16 {
+		t.Errorf("Length byte = %d, block length = %d, want 255, %d", block[0], len(block), 1+255*16)
+	}
+	if !bytes.HasSuffix(block[1:], []byte("';")) {
+		t.Error("Truncated block isn't terminated")
+	}
+}
+
+func TestIcyMetadataTooLongMultibyte(t *testing.T) {
+	block := IcyMetadata(strings.Repeat("ø", 5000))
+	meta := bytes.TrimRight(block[1:], "\x00")
+	if !utf8.Valid(meta) {
+		t.Errorf("Truncated block %q isn't valid UTF-8", meta[len(meta)-8:])
+	}
+	if !bytes.HasSuffix(meta, []byte("ø';")) {
+		t.Errorf("Truncated block ends in %q, want a whole rune and terminator", meta[len(meta)-8:])
+	}
+}
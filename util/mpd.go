@@ -8,12 +8,19 @@ import (
 var supportedCommands = []string{
 	"addid", "list", "play", "playid", "playlistfind", "notcommands",
 	"urlhandlers", "tagtypes", "playlistid", "list", "playlist", "stop", "pause",
-	"currentsong",
+	"currentsong", "next", "previous", "prio", "prioid", "random", "update",
+	"rescan", "idle", "noidle", "playlistinfo", "playlistsearch", "plchanges",
+	"plchangesposid", "radio", "sticker", "subscribe", "unsubscribe",
+	"channels", "readmessages", "sendmessage", "stats", "status", "repeat",
+	"single", "consume", "setvol", "getvol", "clearerror", "partition",
+	"listpartitions", "newpartition", "delpartition", "moveoutput", "password",
+	"seekcur", "deleteid",
+	"lsinfo", "listall", "listallinfo", "listfiles", "outputs", "enableoutput",
+	"disableoutput", "toggleoutput", "outputset", "replay_gain_mode",
+	"replay_gain_status", "crossfade", "mixrampdb", "mixrampdelay",
 }
 
-var notSupportedCommands = []string{
-	"idle", "noidle",
-}
+var notSupportedCommands = []string{}
 
 // MPDSupportedCommands returns list of supported MPD commands.
 func MPDSupportedCommands() string {
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// oggHeaderLength is the length of an Ogg page header, up to its
+	// segment table.
+	oggHeaderLength = 27
+	// OggMaxPageLength is the length of the longest Ogg page.
+	OggMaxPageLength = oggHeaderLength + 255 + 255*255
+	// oggBOS flags the first page of a logical bitstream.
+	oggBOS = 0x02
+)
+
+var errNotOggPage = errors.New("not an Ogg page")
+
+// OggPage is a page of an Ogg bitstream, header included.
+type OggPage []byte
+
+// ReadOggPage reads the Ogg page starting at the beginning of r.
+func ReadOggPage(r io.Reader) (OggPage, error) {
+	header := make([]byte, oggHeaderLength, OggMaxPageLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return nil, errNotOggPage
+	}
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(r, segments); err != nil {
+		return nil, err
+	}
+	length := 0
+	for _, segment := range segments {
+		length += int(segment)
+	}
+	page := append(header, segments...)
+	page = page[:len(page)+length]
+	if _, err := io.ReadFull(r, page[len(page)-length:]); err != nil {
+		return nil, err
+	}
+
+	return OggPage(page), nil
+}
+
+// BOS reports whether the page begins a logical bitstream.
+func (p OggPage) BOS() bool {
+	return p[5]&oggBOS != 0
+}
+
+// Granule returns the page's granule position: 0 on pages holding only
+// codec headers, and -1 on pages no packet ends on.
+func (p OggPage) Granule() int64 {
+	return int64(binary.LittleEndian.Uint64(p[6:14]))
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// oggPage returns an Ogg page with flags and granule, holding packets.
+func oggPage(flags byte, granule int64, packets ...[]byte) []byte {
+	header := make([]byte, oggHeaderLength)
+	copy(header, "OggS")
+	header[5] = flags
+	binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+	var body []byte
+	for _, packet := range packets {
+		header = append(header, byte(len(packet)))
+		body = append(body, packet...)
+	}
+	header[26] = byte(len(packets))
+
+	return append(header, body...)
+}
+
+func TestReadOggPage(t *testing.T) {
+	first := oggPage(oggBOS, 0, []byte("identification"))
+	second := oggPage(0, 4800, []byte("audio"), []byte("more audio"))
+	r := bytes.NewReader(append(append([]byte{}, first...), second...))
+
+	page, err := ReadOggPage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(page, first) || !page.BOS() || page.Granule() != 0 {
+		t.Errorf("First page = %q, BOS %v, granule %d", page, page.BOS(), page.Granule())
+	}
+	page, err = ReadOggPage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(page, second) || page.BOS() || page.Granule() != 4800 {
+		t.Errorf("Second page = %q, BOS %v, granule %d", page, page.BOS(), page.Granule())
+	}
+	if _, err = ReadOggPage(r); err != io.EOF {
+		t.Errorf("ReadOggPage at end = %v, want EOF", err)
+	}
+}
+
+func TestReadOggPageBadCapture(t *testing.T) {
+	page := oggPage(0, 0, []byte("audio"))
+	copy(page, "ID3\x04")
+	if _, err := ReadOggPage(bytes.NewReader(page)); err == nil {
+		t.Error("ReadOggPage succeeded on a page without its capture pattern")
+	}
+}
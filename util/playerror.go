@@ -0,0 +1,74 @@
+package util
+
+import (
+	"strings"
+)
+
+// PlaybackErrorKind classifies playback errors by what can be done about
+// them.
+type PlaybackErrorKind int
+
+const (
+	// OtherError is an error of unknown cause.
+	OtherError PlaybackErrorKind = iota
+	// NetworkError is an error reaching or reading the stream, which may
+	// go away when retried.
+	NetworkError
+	// ExpiredURLError is the service refusing an expired or revoked stream
+	// URL, fixed by fetching a fresh one.
+	ExpiredURLError
+	// DecodeError is an error decoding the stream, which retrying won't fix.
+	DecodeError
+)
+
+// String implements fmt.Stringer.
+func (k PlaybackErrorKind) String() string {
+	switch k {
+	case NetworkError:
+		return "network error"
+	case ExpiredURLError:
+		return "expired stream URL"
+	case DecodeError:
+		return "decode error"
+	default:
+		return "playback error"
+	}
+}
+
+// Retriable reports whether playing the track again may work after an
+// error of kind k.
+func (k PlaybackErrorKind) Retriable() bool {
+	return k == NetworkError || k == ExpiredURLError
+}
+
+// Substrings of GStreamer error messages and debug information telling
+// the kind of an error. Expired URLs are checked first, as souphttpsrc
+// reports them as resource errors too; HTTP statuses are matched in
+// parentheses after a space, as souphttpsrc reports them, lest debug line
+// numbers such as "gstsouphttpsrc.c(403):" match.
+var playbackErrorPatterns = []struct {
+	kind     PlaybackErrorKind
+	patterns []string
+}{
+	{ExpiredURLError, []string{"forbidden", " (403)", "unauthorized", " (401)", " (410)"}},
+	{DecodeError, []string{"decode", "demultiplex", "not-negotiated", "not negotiated",
+		"missing a plug-in", "no suitable plugins", "type not found", "enough data"}},
+	{NetworkError, []string{"resolve", "connect", "timed out", "timeout", "socket",
+		"network", "could not read", "could not open", "secure connection",
+		"internal server error", "bad gateway", "service unavailable"}},
+}
+
+// ClassifyPlaybackError classifies a GStreamer playback error message,
+// with its debug information.
+func ClassifyPlaybackError(message, debug string) PlaybackErrorKind {
+	text := strings.ToLower(message + " " + debug)
+	for _, p := range playbackErrorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(text, pattern) {
+				return p.kind
+			}
+		}
+	}
+
+	return OtherError
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestClassifyPlaybackError(t *testing.T) {
+	tests := []struct {
+		message, debug string
+		want           PlaybackErrorKind
+	}{
+		{"Forbidden", "gstsouphttpsrc.c(1249): Forbidden (403)", ExpiredURLError},
+		{"Unauthorized", "", ExpiredURLError},
+		{"Could not read from resource.", "gstsouphttpsrc.c(1410): Gone (410)", ExpiredURLError},
+		{"Could not resolve server name.", "", NetworkError},
+		{"Could not connect to server", "", NetworkError},
+		{"Could not read from resource.", "gstsouphttpsrc.c(403): Socket I/O timed out", NetworkError},
+		{"Internal Server Error", "gstsouphttpsrc.c(1249): Internal Server Error (500)", NetworkError},
+		{"Internal data stream error.", "qtdemux.c(403): failed to demultiplex", DecodeError},
+		{"Could not decode stream.", "", DecodeError},
+		{"Your GStreamer installation is missing a plug-in.", "", DecodeError},
+		{"Stream doesn't contain enough data.", "", DecodeError},
+		{"Unknown error", "", OtherError},
+		{"", "", OtherError},
+	}
+	for _, test := range tests {
+		if got := ClassifyPlaybackError(test.message, test.debug); got != test.want {
+			t.Errorf("ClassifyPlaybackError(%q, %q) = %s, want %s", test.message, test.debug, got, test.want)
+		}
+	}
+}
+
+func TestPlaybackErrorKindRetriable(t *testing.T) {
+	for kind, want := range map[PlaybackErrorKind]bool{
+		OtherError:      false,
+		NetworkError:    true,
+		ExpiredURLError: true,
+		DecodeError:     false,
+	} {
+		if got := kind.Retriable(); got != want {
+			t.Errorf("%s.Retriable() = %v, want %v", kind, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errBadRange = errors.New("bad range")
+
+// ParseRange parses an MPD range argument, START:END or a single position
+// POS, into the half-open interval [start, end). An omitted END extends the
+// range to length.
+func ParseRange(s string, length int) (start, end int, err error) {
+	fields := strings.SplitN(s, ":", 2)
+	start, err = strconv.Atoi(fields[0])
+	if err != nil || start < 0 {
+		return 0, 0, errBadRange
+	}
+	if len(fields) == 1 {
+		return start, start + 1, nil
+	}
+	if fields[1] == "" {
+		end = length
+	} else {
+		end, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, 0, errBadRange
+		}
+	}
+	if end < start {
+		return 0, 0, errBadRange
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end int
+		ok         bool
+	}{
+		{"3", 3, 4, true},
+		{"2:5", 2, 5, true},
+		{"2:", 2, 10, true},
+		{"5:2", 0, 0, false},
+		{"-1", 0, 0, false},
+		{"a:b", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, err := ParseRange(test.input, 10)
+		if (err == nil) != test.ok || start != test.start || end != test.end {
+			t.Errorf("ParseRange(%q) = %d, %d, %v, want %d, %d, ok %v",
+				test.input, start, end, err, test.start, test.end, test.ok)
+		}
+	}
+}
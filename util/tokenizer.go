@@ -2,6 +2,7 @@
 package util
 
 import (
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -64,21 +65,32 @@ func (t *Tokenizer) nextString() string {
 	if t.peek() == eol {
 		return ""
 	}
-	for t.peek() != '"' {
+	for t.peek() != '"' && t.peek() != eol {
 		if t.peek() == '\\' {
 			t.next()
-		} else {
-			runes = append(runes, t.next())
+			if t.peek() == eol {
+				break
+			}
 		}
+		runes = append(runes, t.next())
 	}
 	t.next()
 	t.consumeSpaces()
 	return string(runes)
 }
 
+// Quote quotes s as a single param, escaping backslashes and double quotes.
+func Quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+
+	return `"` + s + `"`
+}
+
 // next returns the next rune in the input.
 func (t *Tokenizer) next() rune {
 	if int(t.pos) >= len(t.input) {
+		t.width = 0
 		return eol
 	}
 	r, s := utf8.DecodeRuneInString(t.input[t.pos:])
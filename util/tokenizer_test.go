@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -40,3 +41,27 @@ func TestSingleWord(t *testing.T) {
 		t.Errorf("Command = %s, Expected: status", command)
 	}
 }
+
+func TestEscapedQuotedParam(t *testing.T) {
+	tok := NewTokenizer(`find title "Say \"Hi\" \\o/" album "unterminated`)
+	expected := []string{"find", "title", `Say "Hi" \o/`, "album", "unterminated"}
+	for _, want := range expected {
+		if param := tok.NextParam(); param != want {
+			t.Errorf("Param = %q, want %q", param, want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	params := []string{"a b", `Say "Hi"`, `C:\Music`, "tab\there"}
+	var quoted []string
+	for _, param := range params {
+		quoted = append(quoted, Quote(param))
+	}
+	tok := NewTokenizer(strings.Join(quoted, " "))
+	for _, want := range params {
+		if param := tok.NextParam(); param != want {
+			t.Errorf("Param = %q, want %q", param, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	// toneAmplitude is the peak amplitude of generated tones, well below
+	// full scale so they don't clip once gain is applied.
+	toneAmplitude = 0.5 * math.MaxInt16
+	// MaxToneSeconds is the duration of the longest tone generated, long
+	// enough for tests to crossfade and seek.
+	MaxToneSeconds = 60
+	// toneChunk is the number of samples generated at a time.
+	toneChunk = 4096
+)
+
+var errBadTone = errors.New("bad tone")
+
+// CheckTone checks that a tone of freq hertz lasting seconds can be
+// sampled at rate: both are finite and positive, the tone lasts at most
+// MaxToneSeconds, and its frequency is at most half the rate.
+func CheckTone(freq, seconds float64, rate int) error {
+	for _, v := range []float64{freq, seconds} {
+		if math.IsNaN(v) || math.IsInf(v, 0) || v <= 0 {
+			return errBadTone
+		}
+	}
+	if seconds > MaxToneSeconds || freq > float64(rate)/2 {
+		return errBadTone
+	}
+
+	return nil
+}
+
+// WriteToneWAV writes a mono, 16 bit WAV file holding a sine wave of freq
+// hertz lasting seconds, sampled at rate.
+func WriteToneWAV(w io.Writer, freq, seconds float64, rate int) error {
+	if err := CheckTone(freq, seconds, rate); err != nil {
+		return err
+	}
+	samples := int(seconds * float64(rate))
+	dataSize := uint32(samples * 2)
+
+	out := bufio.NewWriter(w)
+	header := []interface{}{
+		[]byte("RIFF"), 36 + dataSize, []byte("WAVE"),
+		[]byte("fmt "), uint32(16),
+		uint16(1),        // PCM
+		uint16(1),        // channels
+		uint32(rate),     // sample rate
+		uint32(rate * 2), // byte rate
+		uint16(2),        // block align
+		uint16(16),       // bits per sample
+		[]byte("data"), dataSize,
+	}
+	for _, field := range header {
+		if err := binary.Write(out, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	chunk := make([]int16, toneChunk)
+	for start := 0; start < samples; start += toneChunk {
+		data := chunk
+		if samples-start < toneChunk {
+			data = chunk[:samples-start]
+		}
+		for i := range data {
+			t := float64(start+i) / float64(rate)
+			data[i] = int16(toneAmplitude * math.Sin(2*math.Pi*freq*t))
+		}
+		if err := binary.Write(out, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
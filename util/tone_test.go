@@ -0,0 +1,72 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestWriteToneWAV(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteToneWAV(&buffer, 440, 0.5, 8000); err != nil {
+		t.Fatal(err)
+	}
+	wav := buffer.Bytes()
+
+	if len(wav) != 44+4000*2 {
+		t.Errorf("Length = %d, want %d", len(wav), 44+4000*2)
+	}
+	if string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" || string(wav[36:40]) != "data" {
+		t.Errorf("Header = %q, not a WAV header", wav[:44])
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:28]); rate != 8000 {
+		t.Errorf("Rate = %d, want 8000", rate)
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:44]); size != 8000 {
+		t.Errorf("Data size = %d, want 8000", size)
+	}
+}
+
+func TestWriteToneWAVRejectsBadTones(t *testing.T) {
+	tests := []struct {
+		freq, seconds float64
+	}{
+		{440, math.Inf(1)},
+		{440, math.NaN()},
+		{math.Inf(1), 1},
+		{math.NaN(), 1},
+		{440, 1e9},
+		{440, MaxToneSeconds + 1},
+		{440, 0},
+		{-440, 1},
+		{4001, 1},
+	}
+	for _, test := range tests {
+		if err := WriteToneWAV(ioutil.Discard, test.freq, test.seconds, 8000); err == nil {
+			t.Errorf("WriteToneWAV(%g, %g) succeeded, want an error", test.freq, test.seconds)
+		}
+	}
+	if err := CheckTone(4000, MaxToneSeconds, 8000); err != nil {
+		t.Errorf("CheckTone(4000, %d) = %s, want nil", MaxToneSeconds, err)
+	}
+}
+
+func TestWriteToneWAVChunks(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteToneWAV(&buffer, 440, 1, 8000); err != nil {
+		t.Fatal(err)
+	}
+	wav := buffer.Bytes()
+	if len(wav) != 44+8000*2 {
+		t.Fatalf("Length = %d, want %d", len(wav), 44+8000*2)
+	}
+	for _, i := range []int{0, toneChunk - 1, toneChunk, 7999} {
+		got := int16(binary.LittleEndian.Uint16(wav[44+2*i:]))
+		want := int16(toneAmplitude * math.Sin(2*math.Pi*440*float64(i)/8000))
+		if got != want {
+			t.Errorf("Sample %d = %d, want %d", i, got, want)
+		}
+	}
+}